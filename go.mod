@@ -1,3 +1,3 @@
 module satanCtl
 
-go 1.15
+go 1.16
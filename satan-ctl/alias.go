@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stProtoAlias is a "type <Name> <type>" declaration. A field of an alias type
+// gets the type tree of the alias with aliasName set on its root, so it is
+// encoded exactly like the aliased type.
+type stProtoAlias struct {
+	line       int
+	name       string
+	typ        *stProtoType
+	filters    []string
+	docComment string
+	comment    string
+}
+
+// parseAliases parses the "type <Name> <type> [filter ...]" declarations.
+// Aliases are resolved before the structs, those referring to other aliases
+// after them.
+func (psr *stProtoParser) parseAliases() error {
+	stmts := make(map[string]*stSyntaxStmt)
+	var names []string
+	for _, st := range psr.syntax {
+		if len(st.words) == 0 || st.words[0] != "type" {
+			continue
+		}
+		if len(st.words) < 3 || st.block != 0 || !regIdent.MatchString(st.words[1]) {
+			return newStCtlError(fmt.Sprintf("line %v: type declaration error, expect type <Name> <type>", st.line))
+		}
+		name := st.words[1]
+		if stmts[name] != nil {
+			return newStCtlError(fmt.Sprintf("line %v: type %v is duplicated", st.line, name))
+		}
+		if err := checkFilters(st.line, st.words[3:]); err != nil {
+			return err
+		}
+		stmts[name] = st
+		names = append(names, name)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		st := stmts[name]
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			return newStCtlError(fmt.Sprintf("line %v: type %v refers to itself: %v -> %v", st.line, name, strings.Join(path[start:], " -> "), name))
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, ref := range regTypeIdent.FindAllString(st.words[2], -1) {
+			if stmts[ref] != nil {
+				if err := resolve(ref); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+
+		typ, err := psr.getStProtocolType(st.words[2])
+		if err != nil {
+			return newStCtlError(fmt.Sprintf("line %v: type %v parse error: type \"%v\" error", st.line, name, st.words[2]))
+		}
+		if typ.dataType == Struct && typ.aliasName == "" {
+			return newStCtlError(fmt.Sprintf("line %v: type %v cannot alias struct %v, use the struct instead", st.line, name, typ.structName))
+		}
+		psr.aliasMap[name] = &stProtoAlias{
+			line:       st.line,
+			name:       name,
+			typ:        typ,
+			filters:    st.words[3:],
+			docComment: st.docText(),
+			comment:    strings.TrimSpace(st.comment),
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := resolve(name); err != nil {
+			return err
+		}
+		psr.aliasList = append(psr.aliasList, psr.aliasMap[name])
+	}
+	return nil
+}
+
+// aliasType returns the type of a field declared with the alias.
+func (pa *stProtoAlias) aliasType() *stProtoType {
+	t := *pa.typ
+	t.aliasName = pa.name
+	return &t
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+// ID names a user.
+type ID long
+type IDs []ID // all of them
+type Owners map[ID]Person
+
+struct Person {
+    id ID
+    ids IDs
+    owners Owners?
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := psr.structMap["Person"].fieldList
+	for i, want := range []string{"ID", "IDs", "Owners"} {
+		if got := fields[i].typ.String(); got != want {
+			t.Errorf("field %v type = %v, want %v", fields[i].name, got, want)
+		}
+	}
+	if typ := fields[1].typ; typ.dataType != List || typ.elem.dataType != Long || typ.elem.aliasName != "ID" {
+		t.Errorf("IDs does not resolve to a list of long: %+v", typ)
+	}
+	if names := fields[2].typ.structNames(); len(names) != 1 || names[0] != "Person" {
+		t.Errorf("unexpected struct names %q", names)
+	}
+	if pa := psr.aliasMap["IDs"]; pa.comment != "all of them" || psr.aliasMap["ID"].docComment != "ID names a user." {
+		t.Errorf("unexpected comments %q, %q", pa.comment, psr.aliasMap["ID"].docComment)
+	}
+
+	for text, want := range map[string]string{
+		"type A int\ntype A long\n":                     "line 2: type A is duplicated",
+		"type A\n":                                      "line 1: type declaration error, expect type <Name> <type>",
+		"type A []B\ntype B map[int]A\n":                "line 1: type A refers to itself: A -> B -> A",
+		"type A []A\n":                                  "line 1: type A refers to itself: A -> A",
+		"type A map[bytes]int\n":                        "line 1: type A parse error: type \"map[bytes]int\" error",
+		"struct S {\n    a int\n}\ntype T S\n":          "line 4: type T cannot alias struct S, use the struct instead",
+		"type S int\nstruct S {\n    a int\n}\n":        "line 2: struct S is declared as a type on line 1 already",
+		"type T []Persn\nstruct Person {\n    a T\n}\n": "line 1: type T: undefined type \"Persn\", did you mean \"Person\"?",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("load %q error = %v, want %v", text, err, want)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// check is the semantic pass run after parsing. It also sets default values,
+// copies the fields of embedded structs into the structs embedding them and
+// numbers the fields. Undefined types, unknown filters, bad filter values, bad
+// embeds, repeated or reserved tags and names, structs that cannot be map keys
+// and recursive struct values are errors, unused structs and map keys JSON
+// cannot encode only end up in psr.warnings.
+func (psr *stProtoParser) check() error {
+	var errs []string
+	for _, pa := range psr.aliasList {
+		for _, name := range pa.typ.declaredStructNames() {
+			if !psr.structNameMap[name] {
+				errs = append(errs, psr.undefinedType(fmt.Sprintf("line %v: type %v", pa.line, pa.name), name))
+			}
+		}
+	}
+	for _, ps := range psr.allStructs() {
+		for _, pe := range ps.embeds {
+			if !psr.structNameMap[pe.name] {
+				errs = append(errs, psr.undefinedType(fmt.Sprintf("line %v: struct %v embed", pe.line, ps.name), pe.name))
+			}
+		}
+		for _, pf := range ps.fieldList {
+			for _, name := range pf.typ.declaredStructNames() {
+				if !psr.structNameMap[name] {
+					errs = append(errs, psr.undefinedType(fmt.Sprintf("line %v: field %v.%v", pf.line, ps.name, pf.name), name))
+				}
+			}
+		}
+	}
+	errs = append(errs, psr.checkFilterNames()...)
+	if len(errs) == 0 {
+		errs = append(errs, psr.checkFieldValues()...)
+		errs = append(errs, psr.checkDeprecated()...)
+		errs = append(errs, psr.checkJSON()...)
+	}
+	if len(errs) == 0 {
+		errs = append(errs, psr.flattenEmbeds()...)
+	}
+	if len(errs) == 0 {
+		errs = append(errs, psr.assignTags()...)
+	}
+	if len(errs) == 0 {
+		errs = append(errs, psr.checkMapKeys()...)
+		errs = append(errs, psr.checkValueCycles()...)
+	}
+	if len(errs) > 0 {
+		return newStCtlError(strings.Join(errs, "\n"))
+	}
+
+	for _, ps := range psr.unusedStructs() {
+		psr.warnings = append(psr.warnings, fmt.Sprintf("line %v: struct %v is not used by any func", ps.line, ps.name))
+	}
+	psr.warnings = append(psr.warnings, psr.jsonMapKeyWarnings()...)
+	return nil
+}
+
+func (psr *stProtoParser) undefinedType(what string, name string) string {
+	msg := fmt.Sprintf("%v: undefined type \"%v\"", what, name)
+	if suggestion := psr.suggestType(name); suggestion != "" {
+		msg += fmt.Sprintf(", did you mean \"%v\"?", suggestion)
+	}
+	return msg
+}
+
+// stFieldFilters are the filters of fields, oneofs and oneof variants, the
+// checks of each filter reject the declarations it does not apply to.
+var stFieldFilters = []string{"default", "deprecated", "go_name", "json", "max_len", "omitempty", "tag"}
+
+// checkFilterNames rejects filters not known on their declaration, so that a
+// misspelled filter is not dropped silently.
+func (psr *stProtoParser) checkFilterNames() []string {
+	var errs []string
+	check := func(line int, what string, filters []string, known []string) {
+		for _, f := range filters {
+			name, _, _ := parseFilter(f)
+			if containsString(known, name) {
+				continue
+			}
+			msg := fmt.Sprintf("line %v: %v: unknown filter \"%v\"", line, what, name)
+			if len(known) == 0 {
+				msg += ", it takes no filters"
+			} else if suggestion := suggestName(name, known); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean \"%v\"?", suggestion)
+			}
+			errs = append(errs, msg)
+		}
+	}
+	for _, pc := range psr.constList {
+		check(pc.line, "const "+pc.name, pc.filters, []string{"go_name"})
+	}
+	for _, pa := range psr.aliasList {
+		check(pa.line, "type "+pa.name, pa.filters, []string{"go_name"})
+	}
+	for _, pe := range psr.errorList {
+		check(pe.line, "error "+pe.name, pe.filters, []string{"go_name", "http"})
+	}
+	for _, ps := range psr.allStructs() {
+		check(ps.line, "struct "+ps.name, ps.filters, []string{"deprecated", "go_name"})
+		for _, pf := range ps.ownFields() {
+			what := fmt.Sprintf("field %v.%v", ps.name, pf.name)
+			check(pf.line, what, pf.filters, stFieldFilters)
+			for _, variant := range pf.typ.variants {
+				check(variant.line, fmt.Sprintf("variant %v.%v.%v", ps.name, pf.name, variant.name), variant.filters, stFieldFilters)
+			}
+		}
+	}
+	for _, pf := range psr.funcList {
+		check(pf.line, "func "+pf.name, pf.filters, []string{"deprecated", "go_name", "idempotent", "oneway", "retry", "timeout"})
+	}
+	for _, sv := range psr.servantList {
+		check(sv.line, "servant "+sv.name, sv.filters, nil)
+	}
+	return errs
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestType returns the struct, alias or base type name closest to an
+// undefined type name, or "" if nothing is close enough.
+func (psr *stProtoParser) suggestType(name string) string {
+	var candidates []string
+	for sName := range psr.structNameMap {
+		candidates = append(candidates, sName)
+	}
+	for aName := range psr.aliasMap {
+		candidates = append(candidates, aName)
+	}
+	for bName := range stBaseTypeMap {
+		candidates = append(candidates, bName)
+	}
+	sort.Strings(candidates)
+	return suggestName(name, candidates)
+}
+
+// suggestName returns the candidate closest to a misspelled name, or "" if
+// nothing is close enough.
+func suggestName(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, name) {
+			return candidate
+		}
+		if d := editDistance(strings.ToLower(candidate), strings.ToLower(name)); d <= bestDist && (best == "" || d < bestDist) {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// flattenEmbeds copies the fields of embedded structs into fieldList at the
+// position of their embed line, so that every backend encodes a struct from
+// its fieldList alone. Embeds may nest but not form a cycle, and the flattened
+// fields need distinct names.
+func (psr *stProtoParser) flattenEmbeds() []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	failed := make(map[string]bool)
+	var errs []string
+
+	var flatten func(ps *stProtoStruct) bool
+	flatten = func(ps *stProtoStruct) bool {
+		if state[ps.name] == done {
+			return !failed[ps.name]
+		}
+		state[ps.name] = visiting
+		defer func() { state[ps.name] = done }()
+
+		var fields []*stProtoField
+		// lines holds the field or embed line each field comes from
+		var lines []int
+		next := 0
+		for _, pe := range ps.embeds {
+			for _, pf := range ps.fieldList[next:pe.pos] {
+				fields, lines = append(fields, pf), append(lines, pf.line)
+			}
+			next = pe.pos
+
+			base := psr.structMap[pe.name]
+			if state[base.name] == visiting {
+				errs = append(errs, fmt.Sprintf("line %v: struct %v: embed %v forms a cycle", pe.line, ps.name, base.name))
+				failed[ps.name] = true
+				continue
+			}
+			if !flatten(base) {
+				failed[ps.name] = true
+				continue
+			}
+			for _, bf := range base.fieldList {
+				f := *bf
+				if f.embeddedFrom == "" {
+					f.embeddedFrom = base.name
+				}
+				f.embed, f.embedBase = pe, bf
+				fields, lines = append(fields, &f), append(lines, pe.line)
+			}
+		}
+		if failed[ps.name] {
+			return false
+		}
+		for _, pf := range ps.fieldList[next:] {
+			fields, lines = append(fields, pf), append(lines, pf.line)
+		}
+
+		seen := make(map[string]int)
+		for i, pf := range fields {
+			if j, ok := seen[pf.name]; ok {
+				errs = append(errs, fmt.Sprintf("line %v: struct %v: field %v%v collides with field %v%v (line %v)",
+					lines[i], ps.name, pf.name, embeddedFromText(pf), pf.name, embeddedFromText(fields[j]), lines[j]))
+				failed[ps.name] = true
+				continue
+			}
+			seen[pf.name] = i
+		}
+		if failed[ps.name] {
+			return false
+		}
+		ps.fieldList = fields
+		return true
+	}
+	for _, ps := range psr.allStructs() {
+		if len(ps.embeds) > 0 {
+			flatten(ps)
+		}
+	}
+	return errs
+}
+
+func embeddedFromText(pf *stProtoField) string {
+	if pf.embeddedFrom == "" {
+		return ""
+	}
+	return " embedded from " + pf.embeddedFrom
+}
+
+// checkMapKeys makes sure that structs used as map keys or set elements can be
+// compared by value in every backend: all their fields are required base types that are
+// valid map keys themselves.
+func (psr *stProtoParser) checkMapKeys() []string {
+	var errs []string
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.ownFields() {
+			pf.typ.walk(func(t *stProtoType) {
+				var key *stProtoType
+				use := "map key"
+				switch t.dataType {
+				case Map:
+					key = t.key
+				case Set:
+					key, use = t.elem, "set element"
+				}
+				if key == nil || key.dataType != Struct {
+					return
+				}
+				for _, kf := range psr.structMap[key.structName].fieldList {
+					if kf.optional || !kf.typ.isMapKeyBase() {
+						errs = append(errs, fmt.Sprintf("line %v: field %v.%v: struct %v cannot be a %v, field %v.%v is not a required base type",
+							pf.line, ps.name, pf.name, key.structName, use, key.structName, kf.name))
+						return
+					}
+				}
+			})
+		}
+	}
+	return errs
+}
+
+// checkValueCycles finds structs that contain themselves through plain struct
+// fields. Such a value can never be complete: one of the fields must stay nil
+// and WriteDataBuf fails on it. Arrays of structs count as plain struct fields,
+// lists, maps and optional fields may be empty, so they break a cycle.
+func (psr *stProtoParser) checkValueCycles() []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var errs []string
+	var path []string
+
+	var visit func(ps *stProtoStruct)
+	visit = func(ps *stProtoStruct) {
+		state[ps.name] = visiting
+		for _, pf := range ps.fieldList {
+			t := pf.typ
+			for t.dataType == Array {
+				t = t.elem
+			}
+			if t.dataType != Struct || pf.optional {
+				continue
+			}
+			path = append(path, fmt.Sprintf("%v.%v", ps.name, pf.name))
+			switch sub := psr.structMap[t.structName]; state[sub.name] {
+			case unvisited:
+				visit(sub)
+			case visiting:
+				start := 0
+				for !strings.HasPrefix(path[start], sub.name+".") {
+					start++
+				}
+				errs = append(errs, fmt.Sprintf("line %v: struct %v contains itself: %v -> %v, use a list, map or optional field to break the cycle",
+					psr.structMap[sub.name].line, sub.name, strings.Join(path[start:], " -> "), sub.name))
+			}
+			path = path[:len(path)-1]
+		}
+		state[ps.name] = done
+	}
+	for _, ps := range psr.structList {
+		if state[ps.name] == unvisited {
+			visit(ps)
+		}
+	}
+	return errs
+}
+
+// unusedStructs returns the declared structs that no func reaches through its
+// req or rsp.
+func (psr *stProtoParser) unusedStructs() []*stProtoStruct {
+	used := make(map[string]bool)
+	var visit func(ps *stProtoStruct)
+	visit = func(ps *stProtoStruct) {
+		for _, pe := range ps.embeds {
+			if sub := psr.structMap[pe.name]; !used[sub.name] {
+				used[sub.name] = true
+				visit(sub)
+			}
+		}
+		for _, pf := range ps.fieldList {
+			for _, name := range pf.typ.structNames() {
+				if sub := psr.structMap[name]; sub != nil && !used[sub.name] {
+					used[sub.name] = true
+					visit(sub)
+				}
+			}
+		}
+	}
+	for _, pf := range psr.funcList {
+		visit(pf.req)
+		if pf.rsp != nil {
+			visit(pf.rsp)
+		}
+	}
+
+	var unused []*stProtoStruct
+	for _, ps := range psr.structList {
+		if !used[ps.name] {
+			unused = append(unused, ps)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stProtoConst is a file-scope "const <Name> <type> = <value>" declaration of
+// a number, bool or string. Filters taking a value, such as default and
+// max_len, accept a const name in place of a literal.
+type stProtoConst struct {
+	line       int
+	name       string
+	typ        stProtocolType
+	value      string
+	filters    []string
+	docComment string
+	comment    string
+}
+
+// stIntBits holds the bit size and signedness of every integer type, which
+// bound the values of its consts.
+var stIntBits = map[stProtocolType]struct {
+	bits     int
+	unsigned bool
+}{
+	Byte:   {8, true},
+	Short:  {16, false},
+	Int:    {32, false},
+	Long:   {64, false},
+	UInt:   {32, true},
+	ULong:  {64, true},
+	Int32:  {32, false},
+	Int64:  {64, false},
+	UInt32: {32, true},
+	UInt64: {64, true},
+}
+
+// regFloatLit rules out the "inf" and "nan" spellings strconv accepts.
+var regFloatLit = regexp.MustCompile(`^[+-]?\.?[0-9]`)
+
+// isConstType reports whether values of the type can be written as literals.
+func isConstType(dt stProtocolType) bool {
+	_, isInt := stIntBits[dt]
+	return isInt || dt == Bool || dt == Float || dt == Double || dt == String
+}
+
+// parseConst parses the "const <Name> <type> = <value> [filter ...]"
+// declarations.
+func (psr *stProtoParser) parseConst() error {
+	for _, st := range psr.syntax {
+		if len(st.words) == 0 || st.words[0] != "const" {
+			continue
+		}
+		if len(st.words) < 5 || st.block != 0 || !regIdent.MatchString(st.words[1]) || st.words[3] != "=" {
+			return newStCtlError(fmt.Sprintf("line %v: const declaration error, expect const <Name> <type> = <value>", st.line))
+		}
+		name := st.words[1]
+		if psr.constMap[name] != nil {
+			return newStCtlError(fmt.Sprintf("line %v: const %v is duplicated", st.line, name))
+		}
+		dt := stBaseTypeMap[st.words[2]]
+		if !isConstType(dt) {
+			return newStCtlError(fmt.Sprintf("line %v: const %v type \"%v\" error, expect a number, bool or string type", st.line, name, st.words[2]))
+		}
+		if err := checkLiteral(dt, st.words[4]); err != nil {
+			return newStCtlError(fmt.Sprintf("line %v: const %v value %v error: %v", st.line, name, st.words[4], err))
+		}
+		if err := checkFilters(st.line, st.words[5:]); err != nil {
+			return err
+		}
+
+		pc := &stProtoConst{
+			line:       st.line,
+			name:       name,
+			typ:        dt,
+			value:      st.words[4],
+			filters:    st.words[5:],
+			docComment: st.docText(),
+			comment:    strings.TrimSpace(st.comment),
+		}
+		psr.constMap[name] = pc
+		psr.constList = append(psr.constList, pc)
+	}
+	return nil
+}
+
+// checkLiteral checks that lit is a value of the type, written the way Go
+// writes it.
+func checkLiteral(dt stProtocolType, lit string) error {
+	if ib, ok := stIntBits[dt]; ok {
+		var err error
+		if ib.unsigned {
+			_, err = strconv.ParseUint(lit, 0, ib.bits)
+		} else {
+			_, err = strconv.ParseInt(lit, 0, ib.bits)
+		}
+		if err != nil {
+			return newStCtlError(fmt.Sprintf("expect %v", dt))
+		}
+		return nil
+	}
+
+	switch dt {
+	case Bool:
+		if lit != "true" && lit != "false" {
+			return newStCtlError("expect true or false")
+		}
+	case Float, Double:
+		bits := 64
+		if dt == Float {
+			bits = 32
+		}
+		if _, err := strconv.ParseFloat(lit, bits); err != nil || !regFloatLit.MatchString(lit) {
+			return newStCtlError(fmt.Sprintf("expect %v", dt))
+		}
+	case String:
+		if _, err := strconv.Unquote(lit); err != nil || !strings.HasPrefix(lit, "\"") {
+			return newStCtlError("expect a quoted string")
+		}
+	}
+	return nil
+}
+
+// checkValue checks that the filter argument text is a literal or a const of
+// the type.
+func (psr *stProtoParser) checkValue(dt stProtocolType, text string) error {
+	if text != "true" && text != "false" && regIdent.MatchString(text) {
+		pc := psr.constMap[text]
+		if pc == nil {
+			return newStCtlError(fmt.Sprintf("undefined const %v", text))
+		}
+		if pc.typ != dt {
+			return newStCtlError(fmt.Sprintf("const %v has type %v, expect %v", text, pc.typ, dt))
+		}
+		return nil
+	}
+	return checkLiteral(dt, text)
+}
+
+// checkLength checks that the filter argument text is a non-negative integer
+// literal or a const of an integer type.
+func (psr *stProtoParser) checkLength(text string) error {
+	if pc := psr.constMap[text]; pc != nil {
+		if _, ok := stIntBits[pc.typ]; !ok {
+			return newStCtlError(fmt.Sprintf("const %v has type %v, expect an integer type", text, pc.typ))
+		}
+		text = pc.value
+	} else if regIdent.MatchString(text) {
+		return newStCtlError(fmt.Sprintf("undefined const %v", text))
+	}
+	if n, err := strconv.ParseInt(text, 0, 64); err != nil || n < 0 {
+		return newStCtlError("expect a non-negative integer")
+	}
+	return nil
+}
+
+// findFilterText returns the argument text of the named filter as written,
+// for filters that take a single value literal, which must keep its quotes.
+func findFilterText(filters []string, name string) (string, bool) {
+	for _, f := range filters {
+		if res := regFilter.FindStringSubmatch(f); res != nil && res[1] == name {
+			return strings.TrimSpace(res[2]), true
+		}
+	}
+	return "", false
+}
+
+// checkFieldValues checks the default and max_len filters of the fields and
+// sets their default values.
+func (psr *stProtoParser) checkFieldValues() []string {
+	var errs []string
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.fieldList {
+			what := fmt.Sprintf("line %v: field %v.%v", pf.line, ps.name, pf.name)
+			if text, ok := findFilterText(pf.filters, "default"); ok {
+				switch {
+				case !isConstType(pf.typ.dataType):
+					errs = append(errs, fmt.Sprintf("%v: default needs a number, bool or string field", what))
+				case pf.optional:
+					errs = append(errs, fmt.Sprintf("%v: an optional field cannot have a default", what))
+				default:
+					if err := psr.checkValue(pf.typ.dataType, text); err != nil {
+						errs = append(errs, fmt.Sprintf("%v: default(%v) error: %v", what, text, err))
+					}
+					pf.defaultValue = text
+				}
+			}
+			if text, ok := findFilterText(pf.filters, "max_len"); ok {
+				switch pf.typ.dataType {
+				case String, Bytes, List, Map, Set:
+					if err := psr.checkLength(text); err != nil {
+						errs = append(errs, fmt.Sprintf("%v: max_len(%v) error: %v", what, text, err))
+					}
+				default:
+					errs = append(errs, fmt.Sprintf("%v: max_len needs a string, bytes, list, map or set field", what))
+				}
+			}
+			for _, variant := range pf.typ.variants {
+				for _, name := range []string{"default", "max_len"} {
+					if _, ok := findFilter(variant.filters, name); ok {
+						errs = append(errs, fmt.Sprintf("line %v: variant %v.%v.%v: %v is not supported on oneof variants", variant.line, ps.name, pf.name, variant.name, name))
+					}
+				}
+			}
+		}
+	}
+	return errs
+}
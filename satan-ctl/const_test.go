@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseConst(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+// MaxItems bounds lists.
+const MaxItems int = 100
+const Name string = "a b" // display name
+const Mask ulong = 0xFFFF_FFFF_FFFF_FFFF
+
+struct Page {
+    items []int max_len(MaxItems)
+    name string default(Name)
+    size short default(-3)
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psr.constList) != 3 {
+		t.Fatalf("got %v consts, want 3", len(psr.constList))
+	}
+	if pc := psr.constMap["Name"]; pc.typ != String || pc.value != `"a b"` || pc.comment != "display name" {
+		t.Errorf("unexpected const %+v", pc)
+	}
+	if pc := psr.constMap["MaxItems"]; pc.docComment != "MaxItems bounds lists." {
+		t.Errorf("unexpected doc comment %q", pc.docComment)
+	}
+	if fields := psr.structMap["Page"].fieldList; fields[1].defaultValue != "Name" || fields[2].defaultValue != "-3" {
+		t.Errorf("unexpected default values %q, %q", fields[1].defaultValue, fields[2].defaultValue)
+	}
+
+	for text, want := range map[string]string{
+		"const A int = 1\nconst A int = 2\n":                              "line 2: const A is duplicated",
+		"const A int 1\n":                                                 "line 1: const declaration error, expect const <Name> <type> = <value>",
+		"const A []int = 1\n":                                             "line 1: const A type \"[]int\" error, expect a number, bool or string type",
+		"const A timestamp = 1\n":                                         "line 1: const A type \"timestamp\" error, expect a number, bool or string type",
+		"const A byte = 256\n":                                            "line 1: const A value 256 error: expect byte",
+		"const A uint = -1\n":                                             "line 1: const A value -1 error: expect uint",
+		"const A double = inf\n":                                          "line 1: const A value inf error: expect double",
+		"const A bool = yes\n":                                            "line 1: const A value yes error: expect true or false",
+		"const A string = abc\n":                                          "line 1: const A value abc error: expect a quoted string",
+		"const A long = 1\nstruct S {\n    a int default(A)\n}\n":         "line 3: field S.a: default(A) error: const A has type long, expect int",
+		"struct S {\n    a int default(B)\n}\n":                           "line 2: field S.a: default(B) error: undefined const B",
+		"struct S {\n    a string default('x')\n}\n":                      "line 2: field S.a: default('x') error: expect a quoted string",
+		"struct S {\n    a int? default(1)\n}\n":                          "line 2: field S.a: an optional field cannot have a default",
+		"struct S {\n    a []int default(1)\n}\n":                         "line 2: field S.a: default needs a number, bool or string field",
+		"const A string = \"\"\nstruct S {\n    a []int max_len(A)\n}\n":  "line 3: field S.a: max_len(A) error: const A has type string, expect an integer type",
+		"struct S {\n    a []int max_len(-1)\n}\n":                        "line 2: field S.a: max_len(-1) error: expect a non-negative integer",
+		"struct S {\n    a int max_len(1)\n}\n":                           "line 2: field S.a: max_len needs a string, bytes, list, map or set field",
+		"struct S {\n    oneof o {\n        a int default(1)\n    }\n}\n": "line 3: variant S.o.a: default is not supported on oneof variants",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("load %q error = %v, want %v", text, err, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+)
+
+// stDeprecatedText is the message of a deprecated filter without one.
+const stDeprecatedText = "no longer supported"
+
+// deprecation returns the message of the deprecated filter of a field, struct
+// or func and whether there is one, e.g. deprecated("use User").
+func deprecation(filters []string) (string, bool) {
+	args, ok := findFilter(filters, "deprecated")
+	if !ok {
+		return "", false
+	}
+	if len(args) == 0 || args[0] == "" {
+		return stDeprecatedText, true
+	}
+	return args[0], true
+}
+
+// checkDeprecated checks that every deprecated filter has at most a message.
+func (psr *stProtoParser) checkDeprecated() []string {
+	var errs []string
+	check := func(line int, what string, filters []string) {
+		if args, ok := findFilter(filters, "deprecated"); ok && len(args) > 1 {
+			errs = append(errs, fmt.Sprintf("line %v: %v: deprecated takes at most one message", line, what))
+		}
+	}
+	for _, ps := range psr.allStructs() {
+		check(ps.line, "struct "+ps.name, ps.filters)
+		for _, pf := range ps.ownFields() {
+			check(pf.line, fmt.Sprintf("field %v.%v", ps.name, pf.name), pf.filters)
+			for _, variant := range pf.typ.variants {
+				check(variant.line, fmt.Sprintf("variant %v.%v.%v", ps.name, pf.name, variant.name), variant.filters)
+			}
+		}
+	}
+	for _, pf := range psr.funcList {
+		check(pf.line, "func "+pf.name, pf.filters)
+	}
+	return errs
+}
+
+// deprecatedStructs returns the deprecated structs by name.
+func (psr *stProtoParser) deprecatedStructs() map[string]string {
+	ret := make(map[string]string)
+	for _, ps := range psr.structList {
+		if msg, ok := deprecation(ps.filters); ok {
+			ret[ps.name] = msg
+		}
+	}
+	return ret
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+// unifiedDiff returns the line diff of a and b in unified format, or "" if
+// they are equal. It uses a plain LCS table, which is fine for schema files.
+func unifiedDiff(nameA, nameB, a, b string) string {
+	if a == b {
+		return ""
+	}
+	al, bl := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the LCS length of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type diffLine struct {
+		op   byte
+		text string
+		a, b int
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			lines = append(lines, diffLine{' ', al[i], i, j})
+			i++
+			j++
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', al[i], i, j})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', bl[j], i, j})
+			j++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %v\n+++ %v\n", nameA, nameB)
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// extend the hunk while changes are at most 2*diffContext lines apart
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := start
+		for k := start; k < len(lines) && k-to <= 2*diffContext; k++ {
+			if lines[k].op != ' ' {
+				to = k
+			}
+		}
+		to += diffContext + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		countA, countB := 0, 0
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				countA++
+			}
+			if l.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%v,%v +%v,%v @@\n", lines[from].a+1, countA, lines[from].b+1, countB)
+		for _, l := range lines[from:to] {
+			sb.WriteString(string(l.op) + l.text + "\n")
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
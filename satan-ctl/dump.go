@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+var Dump = &DumpCommand{}
+
+type DumpCommand struct {
+	directory string
+	format    string
+}
+
+func (c *DumpCommand) ParseArgs(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	directory := fs.String("d", "./", "stproto file directory")
+	format := fs.String("f", "json", "output format, json or yaml")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "yaml" {
+		err := newStCtlError(fmt.Sprintf("unknown dump format \"%v\", use json or yaml", *format))
+		fmt.Println(err)
+		return err
+	}
+
+	c.directory = *directory
+	c.format = *format
+	return nil
+}
+
+func (c *DumpCommand) Description() string {
+	return "\n\t\t解析 stproto 文件并以 JSON/YAML 输出解析结果." +
+		"\n\t\tParse stproto files and print the parsed schema as JSON or YAML."
+}
+
+func (c *DumpCommand) Exec() int {
+	fileList, err := getStProtoFilesPath(c.directory)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	dfList := make([]*stDumpFile, 0)
+	for _, filePath := range fileList {
+		psr, err := loadStProtoFile(filePath)
+		if err != nil {
+			fmt.Printf("%v: %v\n", filePath, err)
+			return 1
+		}
+		dfList = append(dfList, psr.toDumpFile())
+	}
+
+	if c.format == "yaml" {
+		err = writeYAML(os.Stdout, dfList)
+	} else {
+		err = writeJSON(os.Stdout, dfList)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// writeJSON writes v as indented JSON for people and jq, so types such as
+// set<string> are not HTML escaped.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// stDumpFile and its children mirror stProtoParser with exported fields, so
+// that the parsed model can be marshalled as is.
+type stDumpFile struct {
+	File        string          `json:"file"`
+	ServerName  string          `json:"serverName"`
+	ServantName string          `json:"servantName"`
+	Consts      []*stDumpConst  `json:"consts,omitempty"`
+	Errors      []*stDumpError  `json:"errors,omitempty"`
+	Types       []*stDumpType   `json:"types,omitempty"`
+	Structs     []*stDumpStruct `json:"structs"`
+	Funcs       []*stDumpFunc   `json:"funcs"`
+	// Servants group the funcs by name, the first one is named after the
+	// file if there are top-level funcs
+	Servants []*stDumpServant `json:"servants,omitempty"`
+}
+
+type stDumpServant struct {
+	Name    string   `json:"name"`
+	Filters []string `json:"filters,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	Funcs   []string `json:"funcs"`
+}
+
+type stDumpConst struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Value   string   `json:"value"`
+	Filters []string `json:"filters,omitempty"`
+	Doc     string   `json:"doc,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+type stDumpError struct {
+	Name    string   `json:"name"`
+	Code    int      `json:"code"`
+	Message string   `json:"message"`
+	Filters []string `json:"filters,omitempty"`
+	Doc     string   `json:"doc,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// stDumpType is a type alias, fields declared with it carry its name as Type.
+type stDumpType struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Filters []string `json:"filters,omitempty"`
+	Doc     string   `json:"doc,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// stDumpStruct lists the fields of embedded structs in Fields too, at their
+// wire tags, with EmbeddedFrom set.
+type stDumpStruct struct {
+	Name    string         `json:"name"`
+	Filters []string       `json:"filters,omitempty"`
+	Comment string         `json:"comment,omitempty"`
+	Embeds  []string       `json:"embeds,omitempty"`
+	Fields  []*stDumpField `json:"fields"`
+
+	ReservedTags  []int    `json:"reservedTags,omitempty"`
+	ReservedNames []string `json:"reservedNames,omitempty"`
+}
+
+type stDumpField struct {
+	Name          string   `json:"name"`
+	Tag           int      `json:"tag"`
+	Type          string   `json:"type"`
+	DataType      string   `json:"dataType"`
+	SubDataTypes  []string `json:"subDataTypes,omitempty"`
+	SubStructName string   `json:"subStructName,omitempty"`
+	Optional      bool     `json:"optional,omitempty"`
+	Filters       []string `json:"filters,omitempty"`
+	Doc           string   `json:"doc,omitempty"`
+	Comment       string   `json:"comment,omitempty"`
+	DefaultValue  string   `json:"defaultValue,omitempty"`
+	EmbeddedFrom  string   `json:"embeddedFrom,omitempty"`
+	// Variants are the fields of a oneof, tagged on their own
+	Variants []*stDumpField `json:"variants,omitempty"`
+}
+
+type stDumpFunc struct {
+	Name    string        `json:"name"`
+	Filters []string      `json:"filters,omitempty"`
+	Comment string        `json:"comment,omitempty"`
+	Req     *stDumpStruct `json:"req"`
+	// Rsp is nil for a oneway func
+	Rsp *stDumpStruct `json:"rsp,omitempty"`
+	// ReqStream and RspStream mark a req or rsp sent as a stream of messages
+	ReqStream bool `json:"reqStream,omitempty"`
+	RspStream bool `json:"rspStream,omitempty"`
+}
+
+func (psr *stProtoParser) toDumpFile() *stDumpFile {
+	df := &stDumpFile{
+		File:        psr.filePath,
+		ServerName:  psr.serverName,
+		ServantName: psr.servantName,
+		Structs:     make([]*stDumpStruct, 0),
+		Funcs:       make([]*stDumpFunc, 0),
+	}
+	for _, pc := range psr.constList {
+		df.Consts = append(df.Consts, &stDumpConst{
+			Name:    pc.name,
+			Type:    pc.typ.String(),
+			Value:   pc.value,
+			Filters: pc.filters,
+			Doc:     pc.docComment,
+			Comment: pc.comment,
+		})
+	}
+	for _, pe := range psr.errorList {
+		df.Errors = append(df.Errors, &stDumpError{
+			Name:    pe.name,
+			Code:    pe.code,
+			Message: pe.message,
+			Filters: pe.filters,
+			Doc:     pe.docComment,
+			Comment: pe.comment,
+		})
+	}
+	for _, pa := range psr.aliasList {
+		df.Types = append(df.Types, &stDumpType{
+			Name:    pa.name,
+			Type:    pa.typ.String(),
+			Filters: pa.filters,
+			Doc:     pa.docComment,
+			Comment: pa.comment,
+		})
+	}
+	for _, ps := range psr.structList {
+		df.Structs = append(df.Structs, ps.toDumpStruct())
+	}
+	for _, pf := range psr.funcList {
+		df.Funcs = append(df.Funcs, &stDumpFunc{
+			Name:      pf.name,
+			Filters:   pf.filters,
+			Comment:   pf.comment,
+			Req:       pf.req.toDumpStruct(),
+			Rsp:       pf.rsp.toDumpStruct(),
+			ReqStream: pf.reqStream,
+			RspStream: pf.rspStream,
+		})
+	}
+	for _, sv := range psr.servantList {
+		ds := &stDumpServant{Name: sv.name, Filters: sv.filters, Comment: sv.comment}
+		for _, pf := range sv.funcs {
+			ds.Funcs = append(ds.Funcs, pf.name)
+		}
+		df.Servants = append(df.Servants, ds)
+	}
+	return df
+}
+
+func (ps *stProtoStruct) toDumpStruct() *stDumpStruct {
+	if ps == nil {
+		return nil
+	}
+	ds := &stDumpStruct{Name: ps.name, Filters: ps.filters, Comment: ps.comment, Fields: make([]*stDumpField, 0)}
+	for _, pe := range ps.embeds {
+		ds.Embeds = append(ds.Embeds, pe.name)
+	}
+	for _, pf := range ps.fieldList {
+		ds.Fields = append(ds.Fields, pf.toDumpField())
+	}
+	for _, pr := range ps.reserved {
+		ds.ReservedTags = append(ds.ReservedTags, pr.tags...)
+		ds.ReservedNames = append(ds.ReservedNames, pr.names...)
+	}
+	return ds
+}
+
+func (pf *stProtoField) toDumpField() *stDumpField {
+	// the flat dataType/subDataTypes form lists the type tree in preorder
+	var dataTypes []string
+	subStructName := ""
+	if pf.typ.dataType == Oneof {
+		dataTypes = append(dataTypes, Oneof.String())
+	} else {
+		pf.typ.walk(func(t *stProtoType) {
+			dataTypes = append(dataTypes, t.dataType.String())
+			if t.dataType == Struct {
+				subStructName = t.structName
+			}
+		})
+	}
+	df := &stDumpField{
+		Name:          pf.name,
+		Tag:           pf.tag,
+		Type:          pf.typ.String(),
+		DataType:      dataTypes[0],
+		SubDataTypes:  dataTypes[1:],
+		SubStructName: subStructName,
+		Optional:      pf.optional,
+		Filters:       pf.filters,
+		Doc:           pf.docComment,
+		Comment:       pf.comment,
+		DefaultValue:  pf.defaultValue,
+		EmbeddedFrom:  pf.embeddedFrom,
+	}
+	for _, variant := range pf.typ.variants {
+		df.Variants = append(df.Variants, variant.toDumpField())
+	}
+	return df
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestToDumpFile(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, testStProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	df := psr.toDumpFile()
+
+	buff, err := json.Marshal(df.Structs[0].Fields[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"scores","tag":2,"type":"map[string][]int","dataType":"map","subDataTypes":["string","list","int"]}`
+	if string(buff) != want {
+		t.Errorf("got %v, want %v", string(buff), want)
+	}
+	if df.Funcs[0].Req.Name != "SayHiReq" {
+		t.Errorf("unexpected req name %v", df.Funcs[0].Req.Name)
+	}
+}
+
+func TestToDumpFileArraysAndSets(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, "struct A {\n    ids [3]int\n    names set<string>\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"array [int]", "set [string]"} {
+		f := psr.toDumpFile().Structs[0].Fields[i]
+		if got := fmt.Sprintf("%v %v", f.DataType, f.SubDataTypes); got != want {
+			t.Errorf("%v: got %v, want %v", f.Name, got, want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	f := &stDumpField{Name: "ids", Tag: 0, Type: "set<string>", DataType: "set", SubDataTypes: []string{"string"}}
+	if err := writeJSON(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"type": "set<string>"`) {
+		t.Errorf("type is escaped:\n%v", buf.String())
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	var buf bytes.Buffer
+	v := []*stDumpStruct{{
+		Name: "Person",
+		Fields: []*stDumpField{
+			{Name: "tags", Tag: 0, Type: "[]string", DataType: "list", SubDataTypes: []string{"string"}},
+			{Name: "ok", Tag: 1, Type: "bool", DataType: "bool", Comment: "yes"},
+		},
+	}}
+	if err := writeYAML(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"- name: Person",
+		"  fields:",
+		"    - name: tags",
+		"      tag: 0",
+		`      type: "[]string"`,
+		"      dataType: list",
+		"      subDataTypes:",
+		"        - string",
+		"    - name: ok",
+		"      tag: 1",
+		"      type: bool",
+		"      dataType: bool",
+		`      comment: "yes"`,
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("got:\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
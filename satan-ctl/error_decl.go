@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stProtoError is an `error <Name> = <code> "<message>"` declaration. Only the
+// code goes over the wire, so codes are unique within a file and stay out of
+// the range of the satanGo runtime errors.
+type stProtoError struct {
+	line       int
+	name       string
+	code       int
+	message    string
+	httpStatus int
+	filters    []string
+	docComment string
+	comment    string
+}
+
+// Codes from stRuntimeErrorMin to stRuntimeErrorMax belong to the satanGo
+// runtime, e.g. 1004 answers a message that does not decode.
+const (
+	stRuntimeErrorMin = 1000
+	stRuntimeErrorMax = 1999
+)
+
+// parseErrors parses the `error <Name> = <code> "<message>" [filter ...]`
+// declarations.
+func (psr *stProtoParser) parseErrors() error {
+	names := make(map[string]*stProtoError)
+	codes := make(map[int]*stProtoError)
+	for _, st := range psr.syntax {
+		if len(st.words) == 0 || st.words[0] != "error" {
+			continue
+		}
+		if len(st.words) < 5 || st.block != 0 || !regIdent.MatchString(st.words[1]) || st.words[2] != "=" {
+			return newStCtlError(fmt.Sprintf("line %v: error declaration error, expect error <Name> = <code> \"<message>\"", st.line))
+		}
+		name := st.words[1]
+		if other := names[name]; other != nil {
+			return newStCtlError(fmt.Sprintf("line %v: error %v is duplicated", st.line, name))
+		}
+		code, err := strconv.Atoi(st.words[3])
+		if err != nil || code <= 0 {
+			return newStCtlError(fmt.Sprintf("line %v: error %v code %v error, expect a positive number", st.line, name, st.words[3]))
+		}
+		if code >= stRuntimeErrorMin && code <= stRuntimeErrorMax {
+			return newStCtlError(fmt.Sprintf("line %v: error %v code %v is reserved, codes from %v to %v are satanGo runtime errors", st.line, name, code, stRuntimeErrorMin, stRuntimeErrorMax))
+		}
+		if other := codes[code]; other != nil {
+			return newStCtlError(fmt.Sprintf("line %v: error %v code %v is used by error %v (line %v)", st.line, name, code, other.name, other.line))
+		}
+		message, err := strconv.Unquote(st.words[4])
+		if err != nil || !strings.HasPrefix(st.words[4], "\"") {
+			return newStCtlError(fmt.Sprintf("line %v: error %v message %v error, expect a quoted string", st.line, name, st.words[4]))
+		}
+		if err := checkFilters(st.line, st.words[5:]); err != nil {
+			return err
+		}
+		httpStatus := 0
+		if args, ok := findFilter(st.words[5:], "http"); ok {
+			if len(args) == 1 {
+				httpStatus, _ = strconv.Atoi(args[0])
+			}
+			if httpStatus < 400 || httpStatus > 599 {
+				return newStCtlError(fmt.Sprintf("line %v: error %v http error, expect http(<status from 400 to 599>)", st.line, name))
+			}
+		}
+
+		pe := &stProtoError{
+			line:       st.line,
+			name:       name,
+			code:       code,
+			message:    message,
+			httpStatus: httpStatus,
+			filters:    st.words[5:],
+			docComment: st.docText(),
+			comment:    strings.TrimSpace(st.comment),
+		}
+		names[name], codes[code] = pe, pe
+		psr.errorList = append(psr.errorList, pe)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+// NotFound is returned for unknown ids.
+error NotFound = 4004 "user not found"
+error Banned = 4003 "user \"x\" is banned" go_name(ErrForbidden) // no access
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psr.errorList) != 2 {
+		t.Fatalf("got %v errors, want 2", len(psr.errorList))
+	}
+	pe := psr.errorList[0]
+	if pe.name != "NotFound" || pe.code != 4004 || pe.message != "user not found" || pe.docComment != "NotFound is returned for unknown ids." || pe.goName() != "ErrNotFound" {
+		t.Errorf("unexpected error %+v", pe)
+	}
+	pe = psr.errorList[1]
+	if pe.message != `user "x" is banned` || pe.comment != "no access" || pe.goName() != "ErrForbidden" {
+		t.Errorf("unexpected error %+v", pe)
+	}
+
+	for text, want := range map[string]string{
+		"error A = 1 \"a\"\nerror A = 2 \"b\"\n": "line 2: error A is duplicated",
+		"error A = 1 \"a\"\nerror B = 1 \"b\"\n": "line 2: error B code 1 is used by error A (line 1)",
+		"error A 1 \"a\"\n":                      "line 1: error declaration error, expect error <Name> = <code> \"<message>\"",
+		"error A = -1 \"a\"\n":                   "line 1: error A code -1 error, expect a positive number",
+		"error A = 1004 \"a\"\n":                 "line 1: error A code 1004 is reserved, codes from 1000 to 1999 are satanGo runtime errors",
+		"error A = 1 a\n":                        "line 1: error A message a error, expect a quoted string",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestParseErrorHTTPStatus(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+error NotFound = 4004 "user not found" http(404)
+error Banned = 4003 "user is banned"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := psr.errorList[0].httpStatus; got != 404 {
+		t.Errorf("NotFound http status = %v, want 404", got)
+	}
+	if got := psr.errorList[1].httpStatus; got != 0 {
+		t.Errorf("Banned http status = %v, want 0", got)
+	}
+
+	for _, text := range []string{
+		"error A = 1 \"a\" http(200)\n",
+		"error A = 1 \"a\" http(404, 410)\n",
+	} {
+		want := "line 1: error A http error, expect http(<status from 400 to 599>)"
+		if _, err := loadStProtoFile(writeTestStProto(t, text)); err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+}
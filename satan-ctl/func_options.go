@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// stFuncOptions are the call policy filters of a func: timeout(500ms) bounds
+// every call, retry(3) retries a call of an idempotent func that failed in a
+// way the client's invoker reports as retryable, and a oneway func has no
+// rsp(...).
+type stFuncOptions struct {
+	timeout    time.Duration
+	idempotent bool
+	oneway     bool
+	retry      int
+}
+
+// parseFuncOptions reads the call policy filters of a func declared on line.
+func parseFuncOptions(line int, funcName string, filters []string) (stFuncOptions, error) {
+	var opts stFuncOptions
+	what := fmt.Sprintf("line %v: func %v", line, funcName)
+	if args, ok := findFilter(filters, "timeout"); ok {
+		var err error
+		if len(args) == 1 {
+			opts.timeout, err = time.ParseDuration(args[0])
+		}
+		if len(args) != 1 || err != nil || opts.timeout <= 0 {
+			return opts, newStCtlError(fmt.Sprintf("%v: timeout error, expect timeout(<duration>), e.g. timeout(500ms)", what))
+		}
+	}
+	if args, ok := findFilter(filters, "retry"); ok {
+		var err error
+		if len(args) == 1 {
+			opts.retry, err = strconv.Atoi(args[0])
+		}
+		if len(args) != 1 || err != nil || opts.retry <= 0 {
+			return opts, newStCtlError(fmt.Sprintf("%v: retry error, expect retry(<positive number>)", what))
+		}
+	}
+	for _, flag := range []struct {
+		name string
+		set  *bool
+	}{{"idempotent", &opts.idempotent}, {"oneway", &opts.oneway}} {
+		if args, ok := findFilter(filters, flag.name); ok {
+			if len(args) > 0 {
+				return opts, newStCtlError(fmt.Sprintf("%v: %v takes no arguments", what, flag.name))
+			}
+			*flag.set = true
+		}
+	}
+	if opts.retry > 0 && !opts.idempotent {
+		return opts, newStCtlError(fmt.Sprintf("%v: retry needs an idempotent func, a failed call may have been done already", what))
+	}
+	return opts, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFuncOptions(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+func Get timeout(1m30s) idempotent retry(2) {
+    req(
+        id long
+    )
+    rsp(
+        ok bool
+    )
+}
+
+func Notify oneway {
+    req(
+        event string
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := stFuncOptions{timeout: 90 * time.Second, idempotent: true, retry: 2}
+	if got := psr.funcList[0].options; got != want {
+		t.Errorf("got options %+v, want %+v", got, want)
+	}
+	if pf := psr.funcList[1]; !pf.options.oneway || pf.rsp != nil {
+		t.Errorf("Notify is not oneway: %+v", pf)
+	}
+	if got := goDuration(psr.funcList[0].options.timeout); got != "90 * time.Second" {
+		t.Errorf("goDuration = %v", got)
+	}
+
+	const body = "{\n    req(\n        id long\n    )\n    rsp(\n        ok bool\n    )\n}\n"
+	for text, want := range map[string]string{
+		"func F timeout(soon) " + body:                    "line 1: func F: timeout error, expect timeout(<duration>), e.g. timeout(500ms)",
+		"func F timeout(-1s) " + body:                     "line 1: func F: timeout error, expect timeout(<duration>), e.g. timeout(500ms)",
+		"func F idempotent retry(0) " + body:              "line 1: func F: retry error, expect retry(<positive number>)",
+		"func F retry(3) " + body:                         "line 1: func F: retry needs an idempotent func, a failed call may have been done already",
+		"func F oneway(yes) " + body:                      "line 1: func F: oneway takes no arguments",
+		"func F oneway " + body:                           "line 1: oneway func F must have req(...) and no rsp(...)",
+		"func F {\n    req(\n        id long\n    )\n}\n": "line 1: func F must have req(...) and rsp(...)",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+}
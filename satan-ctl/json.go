@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// stJSONCase is the naming convention of JSON field names.
+type stJSONCase string
+
+const (
+	stJSONCaseName  stJSONCase = "name"
+	stJSONCaseCamel stJSONCase = "camel"
+	stJSONCaseSnake stJSONCase = "snake"
+)
+
+func parseJSONCase(s string) (stJSONCase, error) {
+	switch c := stJSONCase(s); c {
+	case stJSONCaseName, stJSONCaseCamel, stJSONCaseSnake:
+		return c, nil
+	}
+	return "", newStCtlError(fmt.Sprintf("unknown json case \"%v\", expect name, camel or snake", s))
+}
+
+// apply converts a field name, e.g. "userID" is "userID", "userID" and
+// "user_id" in the name, camel and snake case.
+func (c stJSONCase) apply(name string) string {
+	runes := []rune(name)
+	isUpper := func(i int) bool { return i < len(runes) && unicode.IsUpper(runes[i]) }
+	switch c {
+	case stJSONCaseCamel:
+		// lower the leading upper case run, but keep the first char of the
+		// next word: "URLPath" is "urlPath"
+		for i := 0; isUpper(i); i++ {
+			if i > 0 && i+1 < len(runes) && !isUpper(i+1) && unicode.IsLetter(runes[i+1]) {
+				break
+			}
+			runes[i] = unicode.ToLower(runes[i])
+		}
+		return string(runes)
+	case stJSONCaseSnake:
+		var b strings.Builder
+		for i, r := range runes {
+			if isUpper(i) {
+				// a word starts after a lower case char or digit, or at the
+				// last upper case char of a run followed by lower case
+				if i > 0 && (!isUpper(i-1) && runes[i-1] != '_' || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+					b.WriteByte('_')
+				}
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return name
+}
+
+// jsonFilterName returns the argument of the json filter, "" if there is none.
+func jsonFilterName(filters []string) string {
+	if args, ok := findFilter(filters, "json"); ok && len(args) == 1 {
+		return args[0]
+	}
+	return ""
+}
+
+// checkJSON checks the json("<name>"), json("-") and omitempty filters of the
+// fields.
+func (psr *stProtoParser) checkJSON() []string {
+	var errs []string
+	check := func(what string, filters []string, variant bool) {
+		if args, ok := findFilter(filters, "json"); ok {
+			switch {
+			case len(args) != 1 || args[0] == "" || strings.ContainsAny(args[0], ",\"\\` "):
+				errs = append(errs, fmt.Sprintf("%v: json error, expect json(\"<name>\") or json(\"-\")", what))
+			case variant && args[0] == "-":
+				errs = append(errs, fmt.Sprintf("%v: json(\"-\") is not supported on oneof variants", what))
+			}
+		}
+		if args, ok := findFilter(filters, "omitempty"); ok {
+			switch {
+			case len(args) > 0:
+				errs = append(errs, fmt.Sprintf("%v: omitempty takes no arguments", what))
+			case variant:
+				errs = append(errs, fmt.Sprintf("%v: omitempty is not supported on oneof variants", what))
+			}
+		}
+	}
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.fieldList {
+			check(fmt.Sprintf("line %v: field %v.%v", pf.line, ps.name, pf.name), pf.filters, false)
+			for _, variant := range pf.typ.variants {
+				check(fmt.Sprintf("line %v: variant %v.%v.%v", variant.line, ps.name, pf.name, variant.name), variant.filters, true)
+			}
+		}
+	}
+	return errs
+}
+
+// jsonMapKeyWarnings reports maps and sets whose keys encoding/json cannot
+// write as object keys: it takes strings, integers and time.Time, but not a
+// type alias of timestamp, which loses the methods of time.Time.
+func (psr *stProtoParser) jsonMapKeyWarnings() []string {
+	var warnings []string
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.ownFields() {
+			if jsonFilterName(pf.filters) == "-" {
+				continue
+			}
+			pf.typ.walk(func(t *stProtoType) {
+				key := t.key
+				if t.dataType == Set {
+					key = t.elem
+				}
+				if key == nil || t.dataType != Map && t.dataType != Set {
+					return
+				}
+				switch {
+				case key.dataType == Bool, key.dataType == Float, key.dataType == Double, key.dataType == Struct,
+					key.dataType == Timestamp && key.aliasName != "":
+					warnings = append(warnings, fmt.Sprintf("line %v: field %v.%v: JSON cannot encode %v keys of type %v, leave the field out with json(\"-\")",
+						pf.line, ps.name, pf.name, t.dataType, key))
+				}
+			})
+		}
+	}
+	return warnings
+}
+
+// setJSONNames names the fields without a json filter in the JSON case.
+func (fd *goFileData) setJSONNames(jsonCase stJSONCase) {
+	for _, sd := range fd.Structs {
+		for _, fld := range sd.Fields {
+			if fld.JSONName == "" {
+				fld.JSONName = jsonCase.apply(fld.Name)
+			}
+			if fld.Oneof == nil {
+				continue
+			}
+			for _, vd := range fld.Oneof.Variants {
+				if vd.JSONName == "" {
+					vd.JSONName = jsonCase.apply(vd.Name)
+				}
+			}
+		}
+	}
+}
+
+// checkJSONNames makes sure that no two fields of a struct or variants of a
+// oneof share a JSON name, encoding/json would silently drop both.
+func (fd *goFileData) checkJSONNames() error {
+	var errs []string
+	unique := func(what string, fields []*goFieldData) {
+		seen := make(map[string]*goFieldData)
+		for _, fld := range fields {
+			if fld.JSONName == "-" {
+				continue
+			}
+			if other := seen[fld.JSONName]; other != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v and %v have the same JSON name \"%v\", rename one of them with json(...)", what, other.Name, fld.Name, fld.JSONName))
+				continue
+			}
+			seen[fld.JSONName] = fld
+		}
+	}
+	for _, sd := range fd.Structs {
+		unique("struct "+sd.Name, sd.Fields)
+		for _, fld := range sd.Fields {
+			if fld.Oneof != nil && !fld.Embedded {
+				unique(fmt.Sprintf("oneof %v.%v", sd.Name, fld.Name), fld.Oneof.Variants)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return newStCtlError(strings.Join(errs, "\n"))
+	}
+	return nil
+}
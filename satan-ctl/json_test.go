@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONCase(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		camel string
+		snake string
+	}{
+		{"id", "id", "id"},
+		{"userID", "userID", "user_id"},
+		{"Name", "name", "name"},
+		{"URLPath", "urlPath", "url_path"},
+		{"HTTPServer2", "httpServer2", "http_server2"},
+		{"user_name", "user_name", "user_name"},
+		{"ID", "id", "id"},
+	} {
+		if got := stJSONCaseName.apply(tc.name); got != tc.name {
+			t.Errorf("name case of %v = %v", tc.name, got)
+		}
+		if got := stJSONCaseCamel.apply(tc.name); got != tc.camel {
+			t.Errorf("camel case of %v = %v, want %v", tc.name, got, tc.camel)
+		}
+		if got := stJSONCaseSnake.apply(tc.name); got != tc.snake {
+			t.Errorf("snake case of %v = %v, want %v", tc.name, got, tc.snake)
+		}
+	}
+	if _, err := parseJSONCase("kebab"); err == nil {
+		t.Error("parseJSONCase accepts kebab")
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	const fn = "\nfunc F {\n    req(\n        s S\n    )\n    rsp(\n        ok bool\n    )\n}\n"
+	for text, want := range map[string]string{
+		"struct S {\n    a int json(a, b)\n}":                            "line 2: field S.a: json error, expect json(\"<name>\") or json(\"-\")",
+		"struct S {\n    a int json(\"a b\")\n}":                         "line 2: field S.a: json error, expect json(\"<name>\") or json(\"-\")",
+		"struct S {\n    a int omitempty(1)\n}":                          "line 2: field S.a: omitempty takes no arguments",
+		"struct S {\n    oneof o {\n        a int json(\"-\")\n    }\n}": "line 3: variant S.o.a: json(\"-\") is not supported on oneof variants",
+		"struct S {\n    oneof o {\n        a int omitempty\n    }\n}":   "line 3: variant S.o.a: omitempty is not supported on oneof variants",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text+fn))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+
+	psr, err := loadStProtoFile(writeTestStProto(t, "struct S {\n    a map[bool]int\n    b set<double> json(\"-\")\n}"+fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "line 2: field S.a: JSON cannot encode map keys of type bool, leave the field out with json(\"-\")"
+	if strings.Join(psr.warnings, "\n") != want {
+		t.Errorf("got warnings %q, want %q", psr.warnings, want)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/token"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var regLowerCamel = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+var regUpperCamel = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+
+// stMaxStructFields is the wire limit of fields per struct, the struct length
+// is written as a single byte.
+const stMaxStructFields = 255
+
+const (
+	lintWarning = "warning"
+	lintError   = "error"
+)
+
+var Lint = &LintCommand{}
+
+type LintCommand struct {
+	directory       string
+	format          string
+	rules           map[string]bool
+	maxFields       int
+	deprecatedTypes map[string]bool
+}
+
+type stLintIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type stLintReport func(line int, severity string, format string, a ...interface{})
+
+type stLintRule struct {
+	description string
+	check       func(c *LintCommand, psr *stProtoParser, report stLintReport)
+}
+
+var stLintRuleMap = map[string]*stLintRule{
+	"naming":          {"fields are lowerCamel, consts, errors, types, structs, servants and funcs UpperCamel", lintNaming},
+	"comment":         {"structs, servants and funcs have a comment", lintComment},
+	"unused":          {"structs are referenced from some func", lintUnused},
+	"go-name":         {"names map to distinct exported Go identifiers", lintGoName},
+	"field-count":     {"structs do not have too many fields", lintFieldCount},
+	"deprecated-type": {"fields do not use deprecated types", lintDeprecatedType},
+	"deprecated":      {"only deprecated fields and structs refer to deprecated structs", lintDeprecated},
+}
+
+func (c *LintCommand) ParseArgs(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	directory := fs.String("d", "./", "stproto file directory")
+	format := fs.String("f", "text", "output format, text, json or github")
+	enable := fs.String("enable", "", "comma separated rules to run, default all")
+	disable := fs.String("disable", "", "comma separated rules to skip")
+	maxFields := fs.Int("max-fields", 64, "warn about structs with more fields")
+	deprecatedTypes := fs.String("deprecated-types", "", "comma separated stproto types to warn about")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of lint:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "Rules:\n")
+		for _, name := range lintRuleNames() {
+			fmt.Fprintf(fs.Output(), "  %v\n    \t%v\n", name, stLintRuleMap[name].description)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" && *format != "github" {
+		err := newStCtlError(fmt.Sprintf("unknown lint format \"%v\", use text, json or github", *format))
+		fmt.Println(err)
+		return err
+	}
+
+	c.rules = make(map[string]bool)
+	for name := range stLintRuleMap {
+		c.rules[name] = *enable == ""
+	}
+	for _, list := range []string{*enable, *disable} {
+		for _, name := range splitList(list) {
+			if stLintRuleMap[name] == nil {
+				err := newStCtlError(fmt.Sprintf("unknown lint rule \"%v\"", name))
+				fmt.Println(err)
+				return err
+			}
+			c.rules[name] = list == *enable
+		}
+	}
+
+	c.directory = *directory
+	c.format = *format
+	c.maxFields = *maxFields
+	c.deprecatedTypes = make(map[string]bool)
+	for _, t := range splitList(*deprecatedTypes) {
+		c.deprecatedTypes[t] = true
+	}
+	return nil
+}
+
+func (c *LintCommand) Description() string {
+	return "\n\t\t按可配置规则检查 stproto 文件." +
+		"\n\t\tCheck stproto files against configurable lint rules."
+}
+
+func (c *LintCommand) Exec() int {
+	fileList, err := getStProtoFilesPath(c.directory)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	issues := make([]*stLintIssue, 0)
+	for _, filePath := range fileList {
+		psr, err := loadStProtoFile(filePath)
+		if err != nil {
+			fmt.Printf("%v: %v\n", filePath, err)
+			return 1
+		}
+		issues = append(issues, c.lint(psr)...)
+	}
+
+	failed := false
+	for _, issue := range issues {
+		failed = failed || issue.Severity == lintError
+		switch c.format {
+		case "github":
+			fmt.Printf("::%v file=%v,line=%v,title=%v::%v\n", issue.Severity, issue.File, issue.Line, issue.Rule, issue.Message)
+		case "text":
+			fmt.Printf("%v:%v: %v: %v (%v)\n", issue.File, issue.Line, issue.Severity, issue.Message, issue.Rule)
+		}
+	}
+	if c.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func (c *LintCommand) lint(psr *stProtoParser) []*stLintIssue {
+	var issues []*stLintIssue
+	for _, name := range lintRuleNames() {
+		if !c.rules[name] {
+			continue
+		}
+		stLintRuleMap[name].check(c, psr, func(line int, severity string, format string, a ...interface{}) {
+			issues = append(issues, &stLintIssue{
+				File:     psr.filePath,
+				Line:     line,
+				Rule:     name,
+				Severity: severity,
+				Message:  fmt.Sprintf(format, a...),
+			})
+		})
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Line < issues[j].Line
+	})
+	return issues
+}
+
+func lintRuleNames() []string {
+	var names []string
+	for name := range stLintRuleMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func splitList(s string) []string {
+	var ret []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func lintNaming(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, pc := range psr.constList {
+		if !regUpperCamel.MatchString(pc.name) {
+			report(pc.line, lintWarning, "const %v should be UpperCamel", pc.name)
+		}
+	}
+	for _, pe := range psr.errorList {
+		if !regUpperCamel.MatchString(pe.name) {
+			report(pe.line, lintWarning, "error %v should be UpperCamel", pe.name)
+		}
+	}
+	for _, pa := range psr.aliasList {
+		if !regUpperCamel.MatchString(pa.name) {
+			report(pa.line, lintWarning, "type %v should be UpperCamel", pa.name)
+		}
+	}
+	for _, ps := range psr.structList {
+		if !regUpperCamel.MatchString(ps.name) {
+			report(ps.line, lintWarning, "struct %v should be UpperCamel", ps.name)
+		}
+	}
+	for _, sv := range psr.servantList {
+		if !sv.fromFile && !regUpperCamel.MatchString(sv.name) {
+			report(sv.line, lintWarning, "servant %v should be UpperCamel", sv.name)
+		}
+	}
+	for _, pf := range psr.funcList {
+		if !regUpperCamel.MatchString(pf.name) {
+			report(pf.line, lintWarning, "func %v should be UpperCamel", pf.name)
+		}
+	}
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.ownFields() {
+			if !regLowerCamel.MatchString(pf.name) {
+				report(pf.line, lintWarning, "field %v.%v should be lowerCamel", ps.name, pf.name)
+			}
+		}
+	}
+}
+
+func lintComment(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, ps := range psr.structList {
+		if ps.comment == "" {
+			report(ps.line, lintWarning, "struct %v has no comment", ps.name)
+		}
+	}
+	for _, sv := range psr.servantList {
+		if !sv.fromFile && sv.comment == "" {
+			report(sv.line, lintWarning, "servant %v has no comment", sv.name)
+		}
+	}
+	for _, pf := range psr.funcList {
+		if pf.comment == "" {
+			report(pf.line, lintWarning, "func %v has no comment", pf.name)
+		}
+	}
+}
+
+func lintUnused(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, ps := range psr.unusedStructs() {
+		report(ps.line, lintWarning, "struct %v is not used by any func", ps.name)
+	}
+}
+
+func lintGoName(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.ownFields() {
+			if _, ok := findFilter(pf.filters, "go_name"); !ok && token.IsKeyword(pf.name) {
+				// st2go capitalises it, but other code generators may choke on it
+				report(pf.line, lintWarning, "field %v.%v is a Go keyword, it is generated as %v", ps.name, pf.name, upperFirstChar(pf.name))
+			}
+		}
+	}
+	for _, p := range psr.goNameProblems() {
+		report(p.line, lintError, "%v", p.msg)
+	}
+}
+
+func lintFieldCount(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, ps := range psr.allStructs() {
+		if n := len(ps.fieldList); n > stMaxStructFields {
+			report(ps.line, lintError, "struct %v has %v fields, the wire format allows %v", ps.name, n, stMaxStructFields)
+		} else if n > c.maxFields {
+			report(ps.line, lintWarning, "struct %v has %v fields, more than %v", ps.name, n, c.maxFields)
+		}
+	}
+}
+
+func lintDeprecatedType(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	for _, ps := range psr.allStructs() {
+		for _, pf := range ps.ownFields() {
+			for _, t := range stTypeNames(pf.typ) {
+				if c.deprecatedTypes[t] {
+					report(pf.line, lintWarning, "field %v.%v uses deprecated type %v", ps.name, pf.name, t)
+				}
+			}
+		}
+	}
+}
+
+func lintDeprecated(c *LintCommand, psr *stProtoParser, report stLintReport) {
+	deprecated := psr.deprecatedStructs()
+	refer := func(line int, what string, filters []string, t *stProtoType) {
+		if _, ok := deprecation(filters); ok {
+			return
+		}
+		for _, name := range t.declaredStructNames() {
+			if msg, ok := deprecated[name]; ok {
+				report(line, lintWarning, "%v uses deprecated struct %v: %v", what, name, msg)
+			}
+		}
+	}
+	for _, ps := range psr.allStructs() {
+		if _, ok := deprecation(ps.filters); ok {
+			continue
+		}
+		for _, pe := range ps.embeds {
+			if msg, ok := deprecated[pe.name]; ok {
+				report(pe.line, lintWarning, "struct %v embeds deprecated struct %v: %v", ps.name, pe.name, msg)
+			}
+		}
+		for _, pf := range ps.ownFields() {
+			what := fmt.Sprintf("field %v.%v", ps.name, pf.name)
+			if pf.typ.dataType != Oneof {
+				refer(pf.line, what, pf.filters, pf.typ)
+				continue
+			}
+			if _, ok := deprecation(pf.filters); ok {
+				continue
+			}
+			for _, variant := range pf.typ.variants {
+				refer(variant.line, fmt.Sprintf("variant %v.%v.%v", ps.name, pf.name, variant.name), variant.filters, variant.typ)
+			}
+		}
+	}
+	for _, pa := range psr.aliasList {
+		refer(pa.line, "type "+pa.name, pa.filters, pa.typ)
+	}
+}
+
+// stTypeNames lists the whole type and every type nested in it, e.g.
+// "[]Person" and "Person" for a list of Person.
+func stTypeNames(t *stProtoType) []string {
+	var names []string
+	t.walk(func(t *stProtoType) {
+		names = append(names, t.String())
+	})
+	return names
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+// Person is a user.
+struct Person {
+    Name string
+    type string
+    f float
+}
+
+struct unused_thing {
+    a int
+}
+
+// SayHi greets.
+func SayHi {
+    req(
+        who Person
+    )
+    rsp(
+        msg map[string][]float
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &LintCommand{}
+	if err := c.ParseArgs([]string{"-disable", "comment", "-deprecated-types", "float"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, issue := range c.lint(psr) {
+		got = append(got, fmt.Sprintf("%v %v %v", issue.Line, issue.Rule, issue.Severity))
+	}
+	want := []string{
+		"4 naming warning",
+		"5 go-name warning",
+		"6 deprecated-type warning",
+		"9 naming warning",
+		"9 unused warning",
+		"19 deprecated-type warning",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLintDeprecated(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+struct Old deprecated("use User") {
+    name string
+}
+
+struct Wrapper deprecated {
+    old Old
+}
+
+struct User {
+    embed Old tag(10)
+    old Old? deprecated
+    olds []Old
+    oneof contact {
+        one Old deprecated
+        other Old
+    }
+}
+
+type Olds map[string]Old
+
+func Get {
+    req(
+        user User
+        olds Olds
+    )
+    rsp(
+        ok bool
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &LintCommand{}
+	if err := c.ParseArgs([]string{"-enable", "deprecated"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, issue := range c.lint(psr) {
+		got = append(got, fmt.Sprintf("%v %v", issue.Line, issue.Message))
+	}
+	want := []string{
+		"11 struct User embeds deprecated struct Old: use User",
+		"13 field User.olds uses deprecated struct Old: use User",
+		"16 variant User.contact.other uses deprecated struct Old: use User",
+		"20 type Olds uses deprecated struct Old: use User",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLintExitStatus(t *testing.T) {
+	for text, want := range map[string]int{
+		`
+struct Person {
+    id int
+}
+`: 0,
+		`
+struct Person {
+    type string
+}
+`: 0,
+		`
+struct Person {
+    id int
+    Id int
+}
+`: 1,
+	} {
+		c := &LintCommand{}
+		if err := c.ParseArgs([]string{"-d", path.Dir(writeTestStProto(t, text))}); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Exec(); got != want {
+			t.Errorf("Exec() = %v, want %v for:%v", got, want, text)
+		}
+	}
+}
@@ -10,11 +10,16 @@ const version = "v1.0.0"
 type StCommand interface {
 	ParseArgs(args []string) error
 	Description() string
-	Exec()
+	// Exec runs the command and returns the exit status, 0 on success.
+	Exec() int
 }
 
 var StCmdMap = map[string]StCommand{
 	"st2go": St2Go,
+	"dump":  Dump,
+	"stdoc": StDoc,
+	"fmt":   StFmt,
+	"lint":  Lint,
 }
 
 func help() {
@@ -30,19 +35,21 @@ func help() {
 	}
 }
 
-func dispatch(cmd string, args []string) {
+func dispatch(cmd string, args []string) int {
 	if (cmd == "help") || (cmd == "-h") {
 		help()
 	} else if (cmd == "version") || (cmd == "-v") {
 		fmt.Printf("SatanCtl version %v\n", version)
 	} else if stCmd := StCmdMap[cmd]; stCmd != nil {
 		if err := stCmd.ParseArgs(args); err != nil {
-			return
+			return 2
 		}
-		stCmd.Exec()
+		return stCmd.Exec()
 	} else {
 		fmt.Printf("unknown command \"%v\", try \"help\".\n", cmd)
+		return 2
 	}
+	return 0
 }
 
 func main() {
@@ -51,5 +58,5 @@ func main() {
 		return
 	}
 
-	dispatch(os.Args[1], os.Args[2:])
+	os.Exit(dispatch(os.Args[1], os.Args[2:]))
 }
@@ -6,18 +6,19 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-var regStruct = regexp.MustCompile(`(?U)[ \t]*struct[ \t]+(?P<structName>[a-zA-Z_][0-9a-zA-Z_]*)[ \n\t]*{(?P<structCtx>(.|[\n])*)}`)
-var regFunc = regexp.MustCompile(`(?U)[ \t]*func[ \t]+(?P<funcName>[a-zA-Z_][0-9a-zA-Z_]*)[ \n\t]*{[ \t\n]*req[ \t\n]*\((?P<req>(.|[\n])*)\)[ \t\n]*rsp[ \t\n]*\((?P<rsp>(.|[\n])*)\)[ \t\n]*}`)
-var regStructField = regexp.MustCompile(
-	"^[ \\t]*(?P<fieldName>[a-zA-Z_][0-9a-zA-Z_]*)" +
-		"[ \\t]+(?P<fieldType>[a-zA-Z0-9_\\[\\]]+)" +
-		"(?P<fieldFilter>[ \\t]+[a-zA-Z0-9,() \\t]*)?" +
-		"(?P<fieldComment>[ \\t]*//[ \\t]*.*)?$",
-)
-var regMapType = regexp.MustCompile(`^map\[(?P<key>[a-z]+)\](?P<value>.+)$`)
+var regIdent = regexp.MustCompile(`^[a-zA-Z_][0-9a-zA-Z_]*$`)
+
+// regTypeIdent finds the names in a type spelling.
+var regTypeIdent = regexp.MustCompile(`[a-zA-Z_][0-9a-zA-Z_]*`)
+var regArrayType = regexp.MustCompile(`^\[([0-9]+)\](.+)$`)
+var regFilter = regexp.MustCompile(`^(?P<name>[a-zA-Z_][0-9a-zA-Z_]*)(?:\((?P<args>.*)\))?$`)
+
+// stMaxArraySize keeps fixed arrays small enough to be held by value.
+const stMaxArraySize = 65535
 
 type stProtocolType byte
 
@@ -33,192 +34,709 @@ const (
 	List
 	Map
 	Struct
+	Short
+	UInt
+	ULong
+	Int32
+	Int64
+	UInt32
+	UInt64
+	Bytes
+	Timestamp
+	Duration
+	Array
+	Set
+	Oneof
 )
 
+var stProtocolTypeNames = [...]string{
+	Unknown:   "unknown",
+	Byte:      "byte",
+	Bool:      "bool",
+	Int:       "int",
+	Long:      "long",
+	Float:     "float",
+	Double:    "double",
+	String:    "string",
+	List:      "list",
+	Map:       "map",
+	Struct:    "struct",
+	Short:     "short",
+	UInt:      "uint",
+	ULong:     "ulong",
+	Int32:     "int32",
+	Int64:     "int64",
+	UInt32:    "uint32",
+	UInt64:    "uint64",
+	Bytes:     "bytes",
+	Timestamp: "timestamp",
+	Duration:  "duration",
+	Array:     "array",
+	Set:       "set",
+	Oneof:     "oneof",
+}
+
+func (t stProtocolType) String() string {
+	if int(t) < len(stProtocolTypeNames) {
+		return stProtocolTypeNames[t]
+	}
+	return stProtocolTypeNames[Unknown]
+}
+
 var stBaseTypeMap = map[string]stProtocolType{
-	"byte":   Byte,
-	"bool":   Bool,
-	"int":    Int,
-	"long":   Long,
-	"float":  Float,
-	"double": Double,
-	"string": String,
+	"byte":      Byte,
+	"bool":      Bool,
+	"int":       Int,
+	"long":      Long,
+	"float":     Float,
+	"double":    Double,
+	"string":    String,
+	"short":     Short,
+	"uint":      UInt,
+	"ulong":     ULong,
+	"int32":     Int32,
+	"int64":     Int64,
+	"uint32":    UInt32,
+	"uint64":    UInt64,
+	"bytes":     Bytes,
+	"timestamp": Timestamp,
+	"duration":  Duration,
+}
+
+// stProtoType is a field type as a tree: lists, arrays and sets carry elem,
+// arrays also their size, maps carry key and value, structs carry structName
+// and oneofs their variants.
+type stProtoType struct {
+	dataType   stProtocolType
+	elem       *stProtoType
+	size       int
+	key        *stProtoType
+	value      *stProtoType
+	structName string
+	variants   []*stProtoField
+	// aliasName is the type alias the field was declared with
+	aliasName string
 }
 
 type stProtoField struct {
-	name          string
-	dataType      stProtocolType
-	subDataTypes  []stProtocolType
-	subStructName string
-	filters       []string
-	comment       string
-	defaultValue  string
+	line         int
+	name         string
+	typ          *stProtoType
+	optional     bool
+	filters      []string
+	docComment   string
+	comment      string
+	defaultValue string
+	// tag is set by assignTags once the embeds are flattened
+	tag int
+	// embeddedFrom is the struct that declares a field copied in by an embed
+	// line, it is empty for the struct's own fields
+	embeddedFrom string
+	// embed and embedBase are set on a copied field: its tag is the tag of
+	// the embed plus the tag of embedBase in the embedded struct
+	embed     *stProtoEmbed
+	embedBase *stProtoField
 }
 
 type stProtoStruct struct {
+	line      int
 	name      string
+	filters   []string
 	comment   string
 	fieldList []*stProtoField
+	embeds    []*stProtoEmbed
+	reserved  []*stProtoReserved
+}
+
+// stProtoEmbed is an "embed <Struct> tag(<n>)" line. The fields of the
+// embedded struct take its place in fieldList, pos is the number of own fields
+// before it. They keep their tags from the embedded struct moved up by tag, so
+// that an embed never renumbers the own fields and a field added to the
+// embedded struct only takes the next tag of its range.
+type stProtoEmbed struct {
+	line int
+	name string
+	pos  int
+	tag  int
 }
 
+// stProtoFunc has no rsp if it is oneway. A req or rsp marked with stream is
+// a sequence of messages instead of a single one.
 type stProtoFunc struct {
-	name    string
-	comment string
-	req     *stProtoStruct
-	rsp     *stProtoStruct
+	line      int
+	name      string
+	filters   []string
+	comment   string
+	options   stFuncOptions
+	req       *stProtoStruct
+	rsp       *stProtoStruct
+	reqStream bool
+	rspStream bool
 }
 
 type stProtoParser struct {
+	filePath      string
 	directory     string
 	serverName    string
 	servantName   string
 	fileText      string
+	syntax        []*stSyntaxStmt
 	tgtFileText   string
+	warnings      []string
 	structNameMap map[string]bool
 	structMap     map[string]*stProtoStruct
+	structList    []*stProtoStruct
 	funcList      []*stProtoFunc
+	servantList   []*stProtoServant
+	constMap      map[string]*stProtoConst
+	constList     []*stProtoConst
+	aliasMap      map[string]*stProtoAlias
+	aliasList     []*stProtoAlias
+	errorList     []*stProtoError
 }
 
-func (psr *stProtoParser) parseOneStruct(structName string, structCtx string) (ps *stProtoStruct, err error) {
-	if structCtx == "" {
-		return nil, newStCtlError(fmt.Sprintf("struct %v is empty, it must have at least one field", structName))
-	}
-
+func (psr *stProtoParser) parseOneStruct(structName string, stmts []*stSyntaxStmt) (ps *stProtoStruct, err error) {
 	if psr.structMap[structName] != nil {
 		return nil, newStCtlError(fmt.Sprintf("struct %v is duplicated", structName))
 	}
 
 	ps = &stProtoStruct{name: structName, fieldList: make([]*stProtoField, 0)}
-	for _, sFieldLine := range strings.Split(structCtx, "\n") {
-		sFieldLine = strings.TrimSpace(sFieldLine)
-		regStructFieldRes := regStructField.FindStringSubmatch(sFieldLine)
-		if len(regStructFieldRes) < 5 {
-			return nil, newStCtlError(fmt.Sprintf("line \"%v\" parse error", sFieldLine))
-		} else {
-			sFieldName := regStructFieldRes[1]
-			sFieldType := regStructFieldRes[2]
-			sFieldFilter := regStructFieldRes[3]
-			sFieldComment := regStructFieldRes[4]
-			dts, subStructName, err := psr.getStProtocolType(sFieldType)
+	for _, st := range stmts {
+		if len(st.words) == 0 {
+			// detached comment
+			continue
+		}
+		if st.words[0] == "embed" && st.block == 0 {
+			pe, err := parseEmbed(structName, st)
 			if err != nil {
-				return nil, newStCtlError(fmt.Sprintf("struct %v parse error: field %v type \"%v\" error", structName, sFieldName, sFieldType))
+				return nil, err
 			}
-
-			pf := &stProtoField{
-				name:          sFieldName,
-				dataType:      dts[0],
-				subDataTypes:  dts[1:],
-				subStructName: subStructName,
-				filters:       strings.Split(sFieldFilter, " "),
-				comment:       sFieldComment,
-				defaultValue:  "",
+			pe.pos = len(ps.fieldList)
+			ps.embeds = append(ps.embeds, pe)
+			continue
+		}
+		if isReservedStmt(st) {
+			pr, err := parseReserved(structName, st)
+			if err != nil {
+				return nil, err
 			}
+			ps.reserved = append(ps.reserved, pr)
+			continue
+		}
+		var pf *stProtoField
+		if st.words[0] == "oneof" && st.block == '{' {
+			pf, err = psr.parseOneof(structName, st)
+		} else {
+			pf, err = psr.parseField(structName, st)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ps.fieldList = append(ps.fieldList, pf)
+	}
+	if len(ps.fieldList) == 0 && len(ps.embeds) == 0 {
+		return nil, newStCtlError(fmt.Sprintf("struct %v is empty, it must have at least one field", structName))
+	}
+	return
+}
+
+// parseField parses a "<name> <type> [filter ...]" field statement.
+func (psr *stProtoParser) parseField(structName string, st *stSyntaxStmt) (*stProtoField, error) {
+	if len(st.words) < 2 || st.block != 0 || !regIdent.MatchString(st.words[0]) {
+		return nil, newStCtlError(fmt.Sprintf("line %v: \"%v\" parse error", st.line, strings.Join(st.words, " ")))
+	}
 
-			ps.fieldList = append(ps.fieldList, pf)
+	sFieldName := st.words[0]
+	sFieldType := st.words[1]
+	// "T?" and the optional filter both mark a field that may be absent
+	optional := strings.HasSuffix(sFieldType, "?")
+	typ, err := psr.getStProtocolType(strings.TrimSuffix(sFieldType, "?"))
+	if err != nil {
+		return nil, newStCtlError(fmt.Sprintf("line %v: struct %v parse error: field %v type \"%v\" error", st.line, structName, sFieldName, sFieldType))
+	}
+	if err := checkFilters(st.line, st.words[2:]); err != nil {
+		return nil, err
+	}
+	var filters []string
+	for _, f := range st.words[2:] {
+		if f == "optional" {
+			optional = true
+		} else {
+			filters = append(filters, f)
 		}
 	}
+
+	return &stProtoField{
+		line:         st.line,
+		name:         sFieldName,
+		typ:          typ,
+		optional:     optional,
+		filters:      filters,
+		docComment:   st.docText(),
+		comment:      strings.TrimSpace(st.comment),
+		defaultValue: "",
+	}, nil
+}
+
+// parseOneof parses a "oneof <name> { <variant fields> }" block. The oneof is
+// a single field of the struct, its variants are tagged on their own.
+func (psr *stProtoParser) parseOneof(structName string, st *stSyntaxStmt) (*stProtoField, error) {
+	oneofName, filters, err := parseDecl(st, '{')
+	if err != nil {
+		return nil, err
+	}
+
+	typ := &stProtoType{dataType: Oneof}
+	for _, child := range st.children {
+		if len(child.words) == 0 {
+			continue
+		}
+		if child.words[0] == "oneof" && child.block != 0 {
+			return nil, newStCtlError(fmt.Sprintf("line %v: oneof %v.%v parse error: oneof cannot be nested", child.line, structName, oneofName))
+		}
+		variant, err := psr.parseField(structName, child)
+		if err != nil {
+			return nil, err
+		}
+		if variant.optional {
+			return nil, newStCtlError(fmt.Sprintf("line %v: oneof %v.%v parse error: variant %v cannot be optional", child.line, structName, oneofName, variant.name))
+		}
+		typ.variants = append(typ.variants, variant)
+	}
+	if len(typ.variants) == 0 {
+		return nil, newStCtlError(fmt.Sprintf("line %v: oneof %v.%v is empty, it must have at least one variant", st.line, structName, oneofName))
+	}
+
+	return &stProtoField{
+		line:       st.line,
+		name:       oneofName,
+		typ:        typ,
+		filters:    filters,
+		docComment: st.docText(),
+		comment:    joinComments(strings.TrimSpace(st.headComment), strings.TrimSpace(st.comment)),
+	}, nil
+}
+
+// parseEmbed parses an "embed <Struct> tag(<n>)" line. embed is a keyword
+// inside struct bodies, so it cannot be used as a field name.
+func parseEmbed(structName string, st *stSyntaxStmt) (*stProtoEmbed, error) {
+	syntaxErr := newStCtlError(fmt.Sprintf("line %v: struct %v parse error: \"%v\", expect embed <Struct> tag(<first tag>)", st.line, structName, strings.Join(st.words, " ")))
+	if len(st.words) != 3 || !regIdent.MatchString(st.words[1]) {
+		return nil, syntaxErr
+	}
+	name, args, err := parseFilter(st.words[2])
+	if err != nil || name != "tag" || len(args) != 1 {
+		return nil, syntaxErr
+	}
+	tag, err := strconv.Atoi(args[0])
+	if err != nil || tag < 0 || tag > stMaxTag {
+		return nil, newStCtlError(fmt.Sprintf("line %v: struct %v: embed %v tag error, expect tag(<number from 0 to %v>)", st.line, structName, st.words[1], stMaxTag))
+	}
+	return &stProtoEmbed{line: st.line, name: st.words[1], tag: tag}, nil
+}
+
+// parseDecl checks that st is a "<keyword> <Name> [filter ...]" declaration
+// followed by a block of the given kind and returns the name and filters.
+func parseDecl(st *stSyntaxStmt, block byte) (string, []string, error) {
+	if len(st.words) < 2 || st.block != block || !regIdent.MatchString(st.words[1]) {
+		return "", nil, newStCtlError(fmt.Sprintf("line %v: %v declaration error", st.line, st.words[0]))
+	}
+	if err := checkFilters(st.line, st.words[2:]); err != nil {
+		return "", nil, err
+	}
+	return st.words[1], st.words[2:], nil
+}
+
+func checkFilters(line int, filters []string) error {
+	for _, f := range filters {
+		if _, _, err := parseFilter(f); err != nil {
+			return newStCtlError(fmt.Sprintf("line %v: filter \"%v\" error: %v", line, f, err))
+		}
+	}
+	return nil
+}
+
+// parseFilter splits a filter such as `deprecated("use x")` into its name and
+// arguments. Arguments are separated by commas, quoted arguments are unquoted.
+func parseFilter(s string) (name string, args []string, err error) {
+	res := regFilter.FindStringSubmatch(s)
+	if res == nil {
+		return "", nil, newStCtlError("expect name or name(args)")
+	}
+	name = res[1]
+	if strings.TrimSpace(res[2]) == "" {
+		return
+	}
+
+	start, quoted := 0, false
+	for i := 0; i <= len(res[2]); i++ {
+		if i < len(res[2]) {
+			switch c := res[2][i]; {
+			case c == '\\' && quoted:
+				i++
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case c != ',' || quoted:
+				continue
+			}
+		}
+		arg := strings.TrimSpace(res[2][start:i])
+		if strings.HasPrefix(arg, "\"") {
+			if arg, err = strconv.Unquote(arg); err != nil {
+				return "", nil, newStCtlError(fmt.Sprintf("bad string %v", res[2][start:i]))
+			}
+		}
+		args = append(args, arg)
+		start = i + 1
+	}
 	return
 }
 
-func (psr *stProtoParser) parseStruct() error {
-	regStructRes := regStruct.FindAllStringSubmatch(psr.fileText, -1)
-	for _, structRes := range regStructRes {
-		if len(structRes) < 4 {
-			return newStCtlError("parse struct error")
+// findFilter returns the arguments of the named filter, the filters have been
+// checked by the parser already.
+func findFilter(filters []string, name string) ([]string, bool) {
+	for _, f := range filters {
+		if fName, args, err := parseFilter(f); err == nil && fName == name {
+			return args, true
 		}
-		structName := strings.TrimSpace(structRes[1])
-		psr.structNameMap[structName] = true
 	}
-	for _, structRes := range regStructRes {
-		if len(structRes) < 3 {
-			return newStCtlError("parse struct error")
+	return nil, false
+}
+
+// stDeclKeywords are the words a top-level declaration starts with, anything
+// else is rejected instead of being skipped like the regex parser did.
+var stDeclKeywords = map[string]bool{
+	"struct":  true,
+	"func":    true,
+	"const":   true,
+	"type":    true,
+	"error":   true,
+	"servant": true,
+}
+
+func (psr *stProtoParser) parseSyntax() error {
+	syntax, err := parseStSyntax(psr.fileText)
+	if err != nil {
+		return err
+	}
+	for _, st := range syntax {
+		if len(st.words) > 0 && !stDeclKeywords[st.words[0]] {
+			return newStCtlError(fmt.Sprintf("line %v: unknown declaration \"%v\"", st.line, st.words[0]))
 		}
+	}
+	psr.syntax = syntax
+	return nil
+}
 
-		structName := strings.TrimSpace(structRes[1])
-		structCtx := strings.TrimSpace(structRes[2])
-		ps, err := psr.parseOneStruct(structName, structCtx)
+func (psr *stProtoParser) parseStruct() error {
+	var structStmts []*stSyntaxStmt
+	for _, st := range psr.syntax {
+		if len(st.words) > 0 && st.words[0] == "struct" {
+			structName, _, err := parseDecl(st, '{')
+			if err != nil {
+				return err
+			}
+			if pa := psr.aliasMap[structName]; pa != nil {
+				return newStCtlError(fmt.Sprintf("line %v: struct %v is declared as a type on line %v already", st.line, structName, pa.line))
+			}
+			psr.structNameMap[structName] = true
+			structStmts = append(structStmts, st)
+		}
+	}
+	for _, st := range structStmts {
+		structName := st.words[1]
+		ps, err := psr.parseOneStruct(structName, st.children)
 		if err != nil {
 			return err
 		}
+		ps.line = st.line
+		ps.filters = st.words[2:]
+		ps.comment = joinComments(st.docText(), strings.TrimSpace(st.headComment))
 		psr.structMap[structName] = ps
+		psr.structList = append(psr.structList, ps)
 	}
 	return nil
 }
 
-func (psr *stProtoParser) parseFunc() error {
-	regFuncRes := regFunc.FindAllStringSubmatch(psr.fileText, -1)
-	for _, funcRes := range regFuncRes {
-		if len(funcRes) < 6 {
-			return newStCtlError("parse func error")
+// parseOneFunc parses a func declaration with its req and rsp structs.
+func (psr *stProtoParser) parseOneFunc(st *stSyntaxStmt) (*stProtoFunc, error) {
+	funcName, filters, err := parseDecl(st, '{')
+	if err != nil {
+		return nil, err
+	}
+
+	var sReq, sRsp *stSyntaxStmt
+	var reqStream, rspStream bool
+	for _, child := range st.children {
+		if len(child.words) == 0 {
+			continue
 		}
-		funcName := strings.TrimSpace(funcRes[1])
-		sReq := strings.TrimSpace(funcRes[2])
-		sRsp := strings.TrimSpace(funcRes[4])
+		// the scanner only opens "(" blocks after req or rsp, optionally
+		// preceded by stream
+		head, stream := child.words[len(child.words)-1], len(child.words) == 2
+		switch {
+		case child.block == '(' && head == "req" && sReq == nil:
+			sReq, reqStream = child, stream
+		case child.block == '(' && head == "rsp" && sRsp == nil:
+			sRsp, rspStream = child, stream
+		default:
+			return nil, newStCtlError(fmt.Sprintf("line %v: func %v parse error: unexpected \"%v\"", child.line, funcName, strings.Join(child.words, " ")))
+		}
+	}
+	opts, err := parseFuncOptions(st.line, funcName, filters)
+	if err != nil {
+		return nil, err
+	}
+	if (reqStream || rspStream) && (opts.oneway || opts.timeout > 0 || opts.retry > 0) {
+		return nil, newStCtlError(fmt.Sprintf("line %v: stream func %v cannot be oneway or have a timeout or retry", st.line, funcName))
+	}
+	if opts.oneway {
+		if sReq == nil || sRsp != nil {
+			return nil, newStCtlError(fmt.Sprintf("line %v: oneway func %v must have req(...) and no rsp(...)", st.line, funcName))
+		}
+	} else if sReq == nil || sRsp == nil {
+		return nil, newStCtlError(fmt.Sprintf("line %v: func %v must have req(...) and rsp(...)", st.line, funcName))
+	}
 
-		psReq, err := psr.parseOneStruct(fmt.Sprintf("%vReq", funcName), sReq)
-		if err != nil {
-			return err
+	psReq, err := psr.parseOneStruct(fmt.Sprintf("%vReq", funcName), sReq.children)
+	if err != nil {
+		return nil, err
+	}
+	psReq.line = sReq.line
+	var psRsp *stProtoStruct
+	if sRsp != nil {
+		if psRsp, err = psr.parseOneStruct(fmt.Sprintf("%vRsp", funcName), sRsp.children); err != nil {
+			return nil, err
 		}
-		psRsp, err := psr.parseOneStruct(fmt.Sprintf("%vRsp", funcName), sRsp)
-		if err != nil {
-			return err
+		psRsp.line = sRsp.line
+	}
+
+	pf := &stProtoFunc{
+		line:      st.line,
+		name:      funcName,
+		filters:   filters,
+		comment:   joinComments(st.docText(), strings.TrimSpace(st.headComment)),
+		options:   opts,
+		req:       psReq,
+		rsp:       psRsp,
+		reqStream: reqStream,
+		rspStream: rspStream,
+	}
+	return pf, nil
+}
+
+func (psr *stProtoParser) parse() error {
+	if err := psr.parseSyntax(); err != nil {
+		return err
+	}
+	if err := psr.parseConst(); err != nil {
+		return err
+	}
+	if err := psr.parseErrors(); err != nil {
+		return err
+	}
+	if err := psr.parseAliases(); err != nil {
+		return err
+	}
+	if err := psr.parseStruct(); err != nil {
+		return err
+	}
+	if err := psr.parseServants(); err != nil {
+		return err
+	}
+	return psr.check()
+}
+
+// allStructs returns the declared structs followed by the req and rsp structs
+// of every func.
+func (psr *stProtoParser) allStructs() []*stProtoStruct {
+	structs := append([]*stProtoStruct{}, psr.structList...)
+	for _, pf := range psr.funcList {
+		structs = append(structs, pf.req)
+		if pf.rsp != nil {
+			structs = append(structs, pf.rsp)
 		}
+	}
+	return structs
+}
 
-		pf := &stProtoFunc{
-			name:    funcName,
-			comment: "",
-			req:     psReq,
-			rsp:     psRsp,
+// ownFields returns the fields declared in the struct itself, without the
+// ones copied in from embedded structs.
+func (ps *stProtoStruct) ownFields() []*stProtoField {
+	var fields []*stProtoField
+	for _, pf := range ps.fieldList {
+		if pf.embeddedFrom == "" {
+			fields = append(fields, pf)
 		}
-		psr.funcList = append(psr.funcList, pf)
 	}
-	return nil
+	return fields
 }
 
-func (psr *stProtoParser) getStProtocolType(s string) (dts []stProtocolType, structName string, err error) {
-	if s == "" {
-		err = newStCtlError("getStProtocolType error")
-	} else if dt := stBaseTypeMap[s]; dt != Unknown {
+// getStProtocolType parses a type spelling. Map keys and set elements are
+// base types or structs; whether a struct can be a key is up to check, as it
+// may be declared later.
+func (psr *stProtoParser) getStProtocolType(s string) (*stProtoType, error) {
+	if dt := stBaseTypeMap[s]; dt != Unknown {
 		// base
-		dts = append(dts, dt)
-	} else if psr.structNameMap[s] {
-		// struct
-		dts = append(dts, Struct)
-		structName = s
-	} else if strings.Index(s, "[]") == 0 {
+		return &stProtoType{dataType: dt}, nil
+	} else if pa := psr.aliasMap[s]; pa != nil {
+		// type alias
+		return pa.aliasType(), nil
+	} else if regIdent.MatchString(s) {
+		// struct, resolved by check
+		return &stProtoType{dataType: Struct, structName: s}, nil
+	} else if strings.HasPrefix(s, "[]") {
 		// list
-		subDts, subStruct, err := psr.getStProtocolType(s[2:])
+		elem, err := psr.getStProtocolType(s[2:])
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
-		dts = append(dts, List)
-		dts = append(dts, subDts...)
-		structName = subStruct
-	} else if regMapTypeRes := regMapType.FindStringSubmatch(s); len(regMapTypeRes) >= 3 {
-		// map
-		key := regMapTypeRes[1]
-		value := regMapTypeRes[2]
+		return &stProtoType{dataType: List, elem: elem}, nil
+	} else if res := regArrayType.FindStringSubmatch(s); res != nil {
+		// array
+		size, err := strconv.Atoi(res[1])
+		if err != nil || size == 0 || size > stMaxArraySize {
+			return nil, newStCtlError("getStProtocolType error")
+		}
+		elem, err := psr.getStProtocolType(res[2])
+		if err != nil {
+			return nil, err
+		}
+		return &stProtoType{dataType: Array, elem: elem, size: size}, nil
+	} else if strings.HasPrefix(s, "set<") && strings.HasSuffix(s, ">") {
+		// set
+		elem, err := psr.getStProtocolType(s[4 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		if elem.dataType != Struct && !elem.isMapKeyBase() {
+			return nil, newStCtlError("getStProtocolType error")
+		}
+		return &stProtoType{dataType: Set, elem: elem}, nil
+	} else if strings.HasPrefix(s, "map[") {
+		// map, the key ends at the matching "]"
+		depth, end := 0, -1
+		for i := 3; i < len(s) && end < 0; i++ {
+			switch s[i] {
+			case '[':
+				depth++
+			case ']':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return nil, newStCtlError("getStProtocolType error")
+		}
+		key, err := psr.getStProtocolType(s[4:end])
+		if err != nil {
+			return nil, err
+		}
+		if key.dataType != Struct && !key.isMapKeyBase() {
+			return nil, newStCtlError("getStProtocolType error")
+		}
+		value, err := psr.getStProtocolType(s[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		return &stProtoType{dataType: Map, key: key, value: value}, nil
+	}
+	return nil, newStCtlError("getStProtocolType error")
+}
+
+func (t *stProtoType) isBase() bool {
+	_, ok := stBaseTypeMap[t.dataType.String()]
+	return ok
+}
+
+// isMapKeyBase reports whether t is a base type usable as a map key, bytes
+// are not comparable and timestamps only compare equal in the same location.
+func (t *stProtoType) isMapKeyBase() bool {
+	return t.isBase() && t.dataType != Bytes && t.dataType != Timestamp
+}
+
+// String rebuilds the stproto spelling of the type, e.g. "map[string][]Person".
+func (t *stProtoType) String() string {
+	if t.aliasName != "" {
+		return t.aliasName
+	}
+	switch t.dataType {
+	case List:
+		return "[]" + t.elem.String()
+	case Array:
+		return fmt.Sprintf("[%v]%v", t.size, t.elem)
+	case Set:
+		return fmt.Sprintf("set<%v>", t.elem)
+	case Map:
+		return fmt.Sprintf("map[%v]%v", t.key, t.value)
+	case Struct:
+		return t.structName
+	default:
+		return t.dataType.String()
+	}
+}
 
-		// key
-		kdt := stBaseTypeMap[key]
-		if kdt == Unknown {
-			err = newStCtlError("getStProtocolType error")
-			return
+// walk calls f for t and every type nested in it, keys before values.
+func (t *stProtoType) walk(f func(t *stProtoType)) {
+	f(t)
+	switch t.dataType {
+	case List, Array, Set:
+		t.elem.walk(f)
+	case Map:
+		t.key.walk(f)
+		t.value.walk(f)
+	case Oneof:
+		for _, variant := range t.variants {
+			variant.typ.walk(f)
 		}
+	}
+}
 
-		// value
-		vdt, subStruct, err := psr.getStProtocolType(value)
-		if err != nil {
-			return nil, "", err
+// structNames lists the structs the type refers to.
+func (t *stProtoType) structNames() []string {
+	var names []string
+	t.walk(func(t *stProtoType) {
+		if t.dataType == Struct {
+			names = append(names, t.structName)
 		}
+	})
+	return names
+}
 
-		dts = append(dts, Map, kdt)
-		dts = append(dts, vdt...)
-		structName = subStruct
-	} else {
-		err = newStCtlError("getStProtocolType error")
+// declaredStructNames lists the structs spelled out in the type, leaving out
+// those behind a type alias, which are checked with the alias.
+func (t *stProtoType) declaredStructNames() []string {
+	if t.aliasName != "" {
+		return nil
 	}
-	return
+	switch t.dataType {
+	case Struct:
+		return []string{t.structName}
+	case List, Array, Set:
+		return t.elem.declaredStructNames()
+	case Map:
+		return append(t.key.declaredStructNames(), t.value.declaredStructNames()...)
+	case Oneof:
+		var names []string
+		for _, variant := range t.variants {
+			names = append(names, variant.typ.declaredStructNames()...)
+		}
+		return names
+	}
+	return nil
+}
+
+func joinComments(comments ...string) string {
+	var ret []string
+	for _, c := range comments {
+		if c != "" {
+			ret = append(ret, c)
+		}
+	}
+	return strings.Join(ret, "\n")
 }
 
 func getStProtoFilesPath(directory string) (fileList []string, err error) {
@@ -237,7 +755,7 @@ func getStProtoFilesPath(directory string) (fileList []string, err error) {
 
 func parseStProtoFile(filePath string) (*stProtoParser, error) {
 	fileName := path.Base(filePath)
-	fileDir :=  path.Dir(filePath)
+	fileDir := path.Dir(filePath)
 	nowDir, _ := os.Getwd()
 	buff, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -245,6 +763,7 @@ func parseStProtoFile(filePath string) (*stProtoParser, error) {
 	}
 
 	psr := &stProtoParser{
+		filePath:      filePath,
 		directory:     fileDir,
 		serverName:    getServerNameFromPath(fileDir, nowDir),
 		servantName:   strings.TrimSuffix(fileName, path.Ext(fileName)),
@@ -252,7 +771,22 @@ func parseStProtoFile(filePath string) (*stProtoParser, error) {
 		tgtFileText:   "",
 		structNameMap: make(map[string]bool),
 		structMap:     make(map[string]*stProtoStruct),
+		structList:    make([]*stProtoStruct, 0),
 		funcList:      make([]*stProtoFunc, 0),
+		constMap:      make(map[string]*stProtoConst),
+		aliasMap:      make(map[string]*stProtoAlias),
+	}
+	return psr, nil
+}
+
+// loadStProtoFile reads and fully parses one stproto file.
+func loadStProtoFile(filePath string) (*stProtoParser, error) {
+	psr, err := parseStProtoFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := psr.parse(); err != nil {
+		return nil, err
 	}
 	return psr, nil
 }
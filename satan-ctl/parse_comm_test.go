@@ -9,4 +9,215 @@ import (
 func TestGetServerNameFromPath(t *testing.T) {
 	nowDir, _ := os.Getwd()
 	fmt.Println(getServerNameFromPath("./", nowDir))
-}
\ No newline at end of file
+}
+
+func TestParseComments(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+// Person is a user.
+//   second line
+struct Person { // trailing
+    // shown to others
+
+    // public name
+    name string // display name
+}
+
+// not attached
+
+// SayHi greets.
+func SayHi {
+    req(
+        who Person
+    )
+    rsp(
+        msg string
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := psr.structMap["Person"].comment; got != "Person is a user.\nsecond line\ntrailing" {
+		t.Errorf("struct comment = %q", got)
+	}
+	if got := psr.structMap["Person"].fieldList[0].comment; got != "display name" {
+		t.Errorf("field comment = %q", got)
+	}
+	if got := psr.structMap["Person"].fieldList[0].docComment; got != "public name" {
+		t.Errorf("field doc comment = %q", got)
+	}
+	if got := psr.funcList[0].comment; got != "SayHi greets." {
+		t.Errorf("func comment = %q", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for text, want := range map[string]string{
+		`
+struct Person {
+    friend Persn
+    tags []Strng
+}
+`: "line 3: field Person.friend: undefined type \"Persn\", did you mean \"Person\"?\n" +
+			"line 4: field Person.tags: undefined type \"Strng\", did you mean \"string\"?",
+		`
+struct A {
+    b B
+}
+struct B {
+    c C
+    list []A
+}
+struct C {
+    a A
+}
+struct Node {
+    next Node
+}
+`: "line 2: struct A contains itself: A.b -> B.c -> C.a -> A, use a list, map or optional field to break the cycle\n" +
+			"line 12: struct Node contains itself: Node.next -> Node, use a list, map or optional field to break the cycle",
+		`
+struct Child {
+    embed Hedaer tag(10)
+}
+struct Header {
+    id int
+}
+`: "line 3: struct Child embed: undefined type \"Hedaer\", did you mean \"Header\"?",
+		`
+struct A {
+    embed B tag(10)
+}
+struct B {
+    embed A tag(10)
+    x int
+}
+`: "line 6: struct B: embed A forms a cycle",
+		`
+struct Header {
+    id int
+}
+struct Child {
+    embed Header tag(10)
+    id string
+}
+`: "line 7: struct Child: field id collides with field id embedded from Header (line 6)",
+		`
+struct Person {
+    nick string deprecated("a", "b")
+}
+`: "line 3: field Person.nick: deprecated takes at most one message",
+		`
+struct Person {
+    nick string jsn("n")
+    id long deprecatd
+}
+func Get timout(1s) {
+    req(
+        id long
+    )
+    rsp(
+        p Person
+    )
+}
+`: "line 3: field Person.nick: unknown filter \"jsn\", did you mean \"json\"?\n" +
+			"line 4: field Person.id: unknown filter \"deprecatd\", did you mean \"deprecated\"?\n" +
+			"line 6: func Get: unknown filter \"timout\", did you mean \"timeout\"?",
+		`
+const MaxAge int = 3 http(404)
+servant Admin internal {
+    func Ban {
+        req(
+            id long
+        )
+        rsp(
+            ok bool
+        )
+    }
+}
+`: "line 2: const MaxAge: unknown filter \"http\"\n" +
+			"line 3: servant Admin: unknown filter \"internal\", it takes no filters",
+		`
+struct Base {
+    traceId string
+}
+struct Person {
+    reserved 1, 5; reserved "nick"
+    id long
+    nick string
+    embed Base tag(5)
+    x int tag(0)
+    oneof o {
+        a int tag(1)
+        b int tag(1)
+    }
+}
+`: "line 10: struct Person: field x: tag 0 collides with id (line 7)\n" +
+			"line 8: struct Person: field nick uses tag 1 reserved on line 6\n" +
+			"line 8: struct Person: field nick uses the name reserved on line 6\n" +
+			"line 3: struct Person: field traceId embedded from Base uses tag 5 reserved on line 6\n" +
+			"line 13: struct Person: variant o.b: tag 1 collides with a (line 12)",
+		`
+struct Header {
+    id int
+    name string
+}
+struct Child {
+    a int
+    embed Header tag(0)
+}
+struct Big {
+    embed Header tag(255)
+}
+`: "line 8: struct Child: field id embedded from Header: tag 0 collides with a (line 7)\n" +
+			"line 11: struct Big: field name embedded from Header: tag 256 is larger than 255, lower the tag of embed Header",
+		`
+struct Child {
+    embed Header
+}
+`: "line 3: struct Child parse error: \"embed Header\", expect embed <Struct> tag(<first tag>)",
+		`
+struct Person {
+    reserved 1 2
+    id long
+}
+`: "line 3: struct Person parse error: \"reserved 1 2\", expect reserved <tag or \"name\">, ...",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("got error:\n%v\nwant:\n%v", err, want)
+		}
+	}
+
+	psr, err := loadStProtoFile(writeTestStProto(t, testStProto+"\nstruct Unused {\n    a int\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psr.warnings) != 1 || psr.warnings[0] != "line 21: struct Unused is not used by any func" {
+		t.Errorf("unexpected warnings %q", psr.warnings)
+	}
+}
+
+func TestParseUnknownDeclaration(t *testing.T) {
+	_, err := loadStProtoFile(writeTestStProto(t, `
+struct Person {
+    name string
+}
+enum Color {
+    red
+}
+`))
+	want := "line 5: unknown declaration \"enum\""
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %v", err, want)
+	}
+}
+
+func TestStProtocolTypeString(t *testing.T) {
+	for typ := Unknown + 1; typ <= Oneof; typ++ {
+		if typ.String() == "unknown" {
+			t.Errorf("stProtocolType %d has no name", typ)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stParenBlockHeads are the statements whose block is written in parentheses,
+// e.g. "req(...)" in a func. They may follow the stream modifier, as in
+// "stream rsp(...)".
+var stParenBlockHeads = map[string]bool{
+	"req": true,
+	"rsp": true,
+}
+
+// isParenBlockHead reports whether the next word of a statement with the given
+// words can start a parenthesized block.
+func isParenBlockHead(words []string) bool {
+	return len(words) == 0 || (len(words) == 1 && words[0] == "stream")
+}
+
+// stSyntaxStmt is one statement of a stproto file: the words up to the end of
+// the line or a ";", optionally followed by a "{...}" or "(...)" block of
+// child statements. A statement without words is a comment block that is not
+// attached to any declaration.
+type stSyntaxStmt struct {
+	line        int
+	words       []string
+	doc         []string
+	comment     string
+	headComment string
+	block       byte
+	children    []*stSyntaxStmt
+	blankBefore bool
+}
+
+// docText is the comment above the statement without its "//" markers.
+func (st *stSyntaxStmt) docText() string {
+	var lines []string
+	for _, line := range st.doc {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// stSyntaxScanner splits stproto text into statements. It only knows about
+// words, blocks and comments; what the words mean is up to stProtoParser.
+type stSyntaxScanner struct {
+	text string
+	pos  int
+	line int
+}
+
+func parseStSyntax(text string) ([]*stSyntaxStmt, error) {
+	sc := &stSyntaxScanner{text: text, line: 1}
+	return sc.scanStmts(0)
+}
+
+func (sc *stSyntaxScanner) peek() byte {
+	if sc.pos >= len(sc.text) {
+		return 0
+	}
+	return sc.text[sc.pos]
+}
+
+func (sc *stSyntaxScanner) hasPrefix(s string) bool {
+	return strings.HasPrefix(sc.text[sc.pos:], s)
+}
+
+func (sc *stSyntaxScanner) skipSpace() {
+	for c := sc.peek(); c == ' ' || c == '\t' || c == '\r'; c = sc.peek() {
+		sc.pos++
+	}
+}
+
+func (sc *stSyntaxScanner) errorf(format string, a ...interface{}) error {
+	return newStCtlError(fmt.Sprintf("line %v: %v", sc.line, fmt.Sprintf(format, a...)))
+}
+
+// scanComment reads a "//" comment up to the end of the line and returns its
+// text without the "//".
+func (sc *stSyntaxScanner) scanComment() string {
+	end := strings.IndexByte(sc.text[sc.pos:], '\n')
+	if end < 0 {
+		end = len(sc.text) - sc.pos
+	}
+	comment := sc.text[sc.pos+2 : sc.pos+end]
+	sc.pos += end
+	return strings.TrimRight(comment, " \t\r")
+}
+
+// scanStmts reads statements until the closing byte end, or the end of the
+// text for end 0.
+func (sc *stSyntaxScanner) scanStmts(end byte) ([]*stSyntaxStmt, error) {
+	var stmts []*stSyntaxStmt
+	var doc []string
+	docLine, docBlank := 0, false
+	newlines := 0
+	flushDoc := func() {
+		if len(doc) > 0 {
+			stmts = append(stmts, &stSyntaxStmt{line: docLine, doc: doc, blankBefore: docBlank})
+			doc = nil
+		}
+	}
+
+	for {
+		sc.skipSpace()
+		c := sc.peek()
+		switch {
+		case c == 0:
+			if end != 0 {
+				return nil, sc.errorf("missing closing \"%c\"", end)
+			}
+			flushDoc()
+			return stmts, nil
+		case c == '\n':
+			sc.pos++
+			sc.line++
+			newlines++
+			if newlines >= 2 && len(doc) > 0 {
+				// a blank line detaches the comment above it
+				flushDoc()
+			}
+		case c == ';':
+			sc.pos++
+		case c == end:
+			sc.pos++
+			flushDoc()
+			return stmts, nil
+		case c == '}' || c == ')':
+			return nil, sc.errorf("unexpected \"%c\"", c)
+		case sc.hasPrefix("//"):
+			if len(doc) == 0 {
+				docLine, docBlank = sc.line, newlines >= 2 && len(stmts) > 0
+			}
+			doc = append(doc, sc.scanComment())
+			newlines = 0
+		default:
+			blank := newlines >= 2 && len(stmts) > 0
+			if len(doc) > 0 {
+				blank = docBlank
+			}
+			st, err := sc.scanStmt(end)
+			if err != nil {
+				return nil, err
+			}
+			st.doc, st.blankBefore = doc, blank
+			doc = nil
+			newlines = 0
+			stmts = append(stmts, st)
+		}
+	}
+}
+
+func (sc *stSyntaxScanner) scanStmt(end byte) (*stSyntaxStmt, error) {
+	st := &stSyntaxStmt{line: sc.line}
+	for {
+		sc.skipSpace()
+		c := sc.peek()
+		switch {
+		case c == 0 || c == '\n' || c == ';' || c == end:
+			return st, nil
+		case sc.hasPrefix("//"):
+			st.comment = sc.scanComment()
+			return st, nil
+		case c == '{' || (c == '(' && len(st.words) > 0 && stParenBlockHeads[st.words[len(st.words)-1]] && isParenBlockHead(st.words[:len(st.words)-1])):
+			closing := byte('}')
+			if c == '(' {
+				closing = ')'
+			}
+			if len(st.words) == 0 {
+				return nil, sc.errorf("block \"%c\" without a declaration", c)
+			}
+			sc.pos++
+			st.block = c
+			sc.skipSpace()
+			if sc.hasPrefix("//") {
+				st.headComment = sc.scanComment()
+			}
+			children, err := sc.scanStmts(closing)
+			if err != nil {
+				return nil, err
+			}
+			st.children = children
+			sc.skipSpace()
+			if sc.hasPrefix("//") {
+				st.comment = sc.scanComment()
+			}
+			return st, nil
+		case c == ',':
+			if len(st.words) == 0 {
+				return nil, sc.errorf("unexpected \",\"")
+			}
+			sc.pos++
+			st.words[len(st.words)-1] += ","
+		case c == '}' || c == ')' || c == '(':
+			return nil, sc.errorf("unexpected \"%c\"", c)
+		default:
+			word, err := sc.scanWord(isParenBlockHead(st.words))
+			if err != nil {
+				return nil, err
+			}
+			st.words = append(st.words, word)
+		}
+	}
+}
+
+// scanWord reads one word. Quoted strings and a parenthesized argument list
+// directly following the word, e.g. `deprecated("use x")`, are part of it.
+func (sc *stSyntaxScanner) scanWord(blockHead bool) (string, error) {
+	start := sc.pos
+	for {
+		c := sc.peek()
+		switch {
+		case c == 0 || c == ' ' || c == '\t' || c == '\r' || c == '\n' ||
+			c == ';' || c == ',' || c == '{' || c == '}' || c == ')' || sc.hasPrefix("//"):
+			return sc.text[start:sc.pos], nil
+		case c == '"':
+			if err := sc.skipString(); err != nil {
+				return "", err
+			}
+		case c == '(':
+			if blockHead && stParenBlockHeads[sc.text[start:sc.pos]] {
+				return sc.text[start:sc.pos], nil
+			}
+			if err := sc.skipArgs(); err != nil {
+				return "", err
+			}
+		default:
+			sc.pos++
+		}
+	}
+}
+
+func (sc *stSyntaxScanner) skipString() error {
+	for sc.pos++; sc.pos < len(sc.text); sc.pos++ {
+		switch sc.text[sc.pos] {
+		case '\\':
+			sc.pos++
+		case '\n':
+			return sc.errorf("newline in string")
+		case '"':
+			sc.pos++
+			return nil
+		}
+	}
+	return sc.errorf("string not terminated")
+}
+
+func (sc *stSyntaxScanner) skipArgs() error {
+	for sc.pos++; sc.pos < len(sc.text); {
+		switch sc.text[sc.pos] {
+		case '"':
+			if err := sc.skipString(); err != nil {
+				return err
+			}
+		case '\n':
+			return sc.errorf("missing closing \")\"")
+		case ')':
+			sc.pos++
+			return nil
+		default:
+			sc.pos++
+		}
+	}
+	return sc.errorf("missing closing \")\"")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stMaxTag is the largest tag, tags are written as a single byte.
+const stMaxTag = 255
+
+// stProtoReserved is a `reserved 3, 5` or `reserved "oldName"` statement of a
+// struct body. It retires the tags and names of removed fields, so that no
+// later field silently takes over their wire data.
+type stProtoReserved struct {
+	line  int
+	tags  []int
+	names []string
+}
+
+// isReservedStmt tells a reserved statement from a field named reserved.
+func isReservedStmt(st *stSyntaxStmt) bool {
+	return st.words[0] == "reserved" && st.block == 0 && len(st.words) > 1 &&
+		strings.IndexAny(st.words[1][:1], "0123456789\"") == 0
+}
+
+// parseReserved parses a `reserved 3, 5` or `reserved "oldName"` statement.
+func parseReserved(structName string, st *stSyntaxStmt) (*stProtoReserved, error) {
+	pr := &stProtoReserved{line: st.line}
+	for i, word := range st.words[1:] {
+		last := i == len(st.words)-2
+		item := strings.TrimSuffix(word, ",")
+		if last == (item != word) {
+			return nil, newStCtlError(fmt.Sprintf("line %v: struct %v parse error: \"%v\", expect reserved <tag or \"name\">, ...", st.line, structName, strings.Join(st.words, " ")))
+		}
+		if strings.HasPrefix(item, "\"") {
+			name, err := strconv.Unquote(item)
+			if err != nil || !regIdent.MatchString(name) {
+				return nil, newStCtlError(fmt.Sprintf("line %v: struct %v parse error: reserved name %v is not a field name", st.line, structName, item))
+			}
+			pr.names = append(pr.names, name)
+			continue
+		}
+		tag, err := strconv.Atoi(item)
+		if err != nil || tag < 0 || tag > stMaxTag {
+			return nil, newStCtlError(fmt.Sprintf("line %v: struct %v parse error: reserved tag %v, expect a number from 0 to %v", st.line, structName, item, stMaxTag))
+		}
+		pr.tags = append(pr.tags, tag)
+	}
+	return pr, nil
+}
+
+// assignTags numbers the fields of every struct after the embeds have been
+// flattened, and checks that the tags and names are neither repeated nor
+// reserved.
+func (psr *stProtoParser) assignTags() []string {
+	var errs []string
+	// the tags of embedded fields build on the tags in the embedded struct
+	for _, ps := range psr.allStructs() {
+		errs = append(errs, numberFields(ps.name, "", ps.ownFields())...)
+	}
+	for _, ps := range psr.allStructs() {
+		errs = append(errs, numberEmbeddedFields(ps)...)
+
+		reservedTags := make(map[int]int)
+		reservedNames := make(map[string]int)
+		for _, pr := range ps.reserved {
+			for _, tag := range pr.tags {
+				reservedTags[tag] = pr.line
+			}
+			for _, name := range pr.names {
+				reservedNames[name] = pr.line
+			}
+		}
+		for _, pf := range ps.fieldList {
+			if line, ok := reservedTags[pf.tag]; ok {
+				errs = append(errs, fmt.Sprintf("line %v: struct %v: field %v%v uses tag %v reserved on line %v", pf.line, ps.name, pf.name, embeddedFromText(pf), pf.tag, line))
+			}
+			if line, ok := reservedNames[pf.name]; ok {
+				errs = append(errs, fmt.Sprintf("line %v: struct %v: field %v%v uses the name reserved on line %v", pf.line, ps.name, pf.name, embeddedFromText(pf), line))
+			}
+			if pf.typ.dataType == Oneof && pf.embeddedFrom == "" {
+				errs = append(errs, numberFields(ps.name, pf.name, pf.typ.variants)...)
+			}
+		}
+	}
+	return errs
+}
+
+// numberEmbeddedFields sets the tags of the fields copied in by the embeds of
+// a struct whose own fields are numbered already.
+func numberEmbeddedFields(ps *stProtoStruct) []string {
+	var errs []string
+	seen := make(map[int]*stProtoField)
+	for _, pf := range ps.ownFields() {
+		seen[pf.tag] = pf
+	}
+	for _, pf := range ps.fieldList {
+		if pf.embed == nil {
+			continue
+		}
+		what := fmt.Sprintf("line %v: struct %v: field %v%v", pf.embed.line, ps.name, pf.name, embeddedFromText(pf))
+		tag := embeddedTag(pf)
+		if tag > stMaxTag {
+			errs = append(errs, fmt.Sprintf("%v: tag %v is larger than %v, lower the tag of embed %v", what, tag, stMaxTag, pf.embed.name))
+			continue
+		}
+		if other := seen[tag]; other != nil {
+			errs = append(errs, fmt.Sprintf("%v: tag %v collides with %v%v (line %v)", what, tag, other.name, embeddedFromText(other), other.line))
+			continue
+		}
+		seen[tag] = pf
+		pf.tag = tag
+	}
+	return errs
+}
+
+// embeddedTag is the tag of pf in the struct it is copied into.
+func embeddedTag(pf *stProtoField) int {
+	if pf.embed == nil {
+		return pf.tag
+	}
+	return pf.embed.tag + embeddedTag(pf.embedBase)
+}
+
+// numberFields sets the tags of the fields of a struct, or of the variants of
+// its oneof if oneofName is set. Tags count up from 0, a field with a tag
+// filter takes its tag and the count goes on from there.
+func numberFields(structName string, oneofName string, fields []*stProtoField) []string {
+	var errs []string
+	next := 0
+	seen := make(map[int]*stProtoField)
+	for _, pf := range fields {
+		what := fmt.Sprintf("line %v: struct %v: field %v%v", pf.line, structName, pf.name, embeddedFromText(pf))
+		if oneofName != "" {
+			what = fmt.Sprintf("line %v: struct %v: variant %v.%v", pf.line, structName, oneofName, pf.name)
+		}
+		tag := next
+		if args, ok := findFilter(pf.filters, "tag"); ok {
+			n := -1
+			if len(args) == 1 {
+				if v, err := strconv.Atoi(args[0]); err == nil {
+					n = v
+				}
+			}
+			if n < 0 || n > stMaxTag {
+				errs = append(errs, fmt.Sprintf("%v: tag error, expect tag(<number from 0 to %v>)", what, stMaxTag))
+				continue
+			}
+			tag = n
+		}
+		if tag > stMaxTag {
+			errs = append(errs, fmt.Sprintf("%v: tag %v is larger than %v", what, tag, stMaxTag))
+			continue
+		}
+		if other := seen[tag]; other != nil {
+			errs = append(errs, fmt.Sprintf("%v: tag %v collides with %v%v (line %v)", what, tag, other.name, embeddedFromText(other), other.line))
+			continue
+		}
+		seen[tag] = pf
+		pf.tag = tag
+		next = tag + 1
+	}
+	return errs
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stProtoServant is a "servant <Name> { func ... }" block, or the servant
+// named after the file holding the top-level funcs if fromFile is set. The
+// servants of a file share its structs, so func names are unique in the file.
+type stProtoServant struct {
+	line     int
+	name     string
+	filters  []string
+	comment  string
+	fromFile bool
+	funcs    []*stProtoFunc
+}
+
+// parseServants parses the top-level funcs into the file servant and the
+// funcs of every servant block into their own servant.
+func (psr *stProtoParser) parseServants() error {
+	fileServant := &stProtoServant{line: 1, name: psr.servantName, fromFile: true}
+	funcs := make(map[string]*stProtoFunc)
+	addFunc := func(sv *stProtoServant, st *stSyntaxStmt) error {
+		pf, err := psr.parseOneFunc(st)
+		if err != nil {
+			return err
+		}
+		if other := funcs[pf.name]; other != nil {
+			return newStCtlError(fmt.Sprintf("line %v: func %v is duplicated, it is declared on line %v already", st.line, pf.name, other.line))
+		}
+		funcs[pf.name] = pf
+		sv.funcs = append(sv.funcs, pf)
+		psr.funcList = append(psr.funcList, pf)
+		return nil
+	}
+
+	// servants whose Go names are equal collide, e.g. account and Account
+	servants := make(map[string]*stProtoServant)
+	var blocks []*stProtoServant
+	for _, st := range psr.syntax {
+		if len(st.words) == 0 {
+			continue
+		}
+		switch st.words[0] {
+		case "func":
+			if err := addFunc(fileServant, st); err != nil {
+				return err
+			}
+		case "servant":
+			name, filters, err := parseDecl(st, '{')
+			if err != nil {
+				return err
+			}
+			if other := servants[upperFirstChar(name)]; other != nil {
+				return newStCtlError(fmt.Sprintf("line %v: servant %v is duplicated, servant %v is declared on line %v already", st.line, name, other.name, other.line))
+			}
+			sv := &stProtoServant{
+				line:    st.line,
+				name:    name,
+				filters: filters,
+				comment: joinComments(st.docText(), strings.TrimSpace(st.headComment)),
+			}
+			servants[upperFirstChar(name)] = sv
+			for _, child := range st.children {
+				if len(child.words) == 0 {
+					continue
+				}
+				if child.words[0] != "func" {
+					return newStCtlError(fmt.Sprintf("line %v: servant %v parse error: unexpected \"%v\", expect func declarations", child.line, name, strings.Join(child.words, " ")))
+				}
+				if err := addFunc(sv, child); err != nil {
+					return err
+				}
+			}
+			if len(sv.funcs) == 0 {
+				return newStCtlError(fmt.Sprintf("line %v: servant %v has no funcs", st.line, name))
+			}
+			blocks = append(blocks, sv)
+		}
+	}
+
+	if len(fileServant.funcs) > 0 {
+		if other := servants[upperFirstChar(fileServant.name)]; other != nil {
+			return newStCtlError(fmt.Sprintf("line %v: servant %v has the name of the file, which names the servant of the top-level funcs", other.line, other.name))
+		}
+		psr.servantList = append(psr.servantList, fileServant)
+	}
+	psr.servantList = append(psr.servantList, blocks...)
+	return nil
+}
+
+func (sv *stProtoServant) hasStreamFunc() bool {
+	for _, pf := range sv.funcs {
+		if pf.reqStream || pf.rspStream {
+			return true
+		}
+	}
+	return false
+}
+
+func (sv *stProtoServant) hasDeprecatedFunc() bool {
+	for _, pf := range sv.funcs {
+		if _, ok := deprecation(pf.filters); ok {
+			return true
+		}
+	}
+	return false
+}
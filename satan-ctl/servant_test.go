@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseServants(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+func Ping {
+    req(
+        id int
+    )
+    rsp(
+        id int
+    )
+}
+
+// Account handles sign in.
+servant Account {
+    func Login {
+        req(
+            name string
+        )
+        rsp(
+            ok bool
+        )
+    }
+
+    func Logout oneway {
+        req(
+            name string
+        )
+    }
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psr.servantList) != 2 {
+		t.Fatalf("got %v servants, want 2", len(psr.servantList))
+	}
+	if sv := psr.servantList[0]; !sv.fromFile || sv.name != psr.servantName || len(sv.funcs) != 1 || sv.funcs[0].name != "Ping" {
+		t.Errorf("unexpected file servant %+v", sv)
+	}
+	if sv := psr.servantList[1]; sv.fromFile || sv.name != "Account" || sv.comment != "Account handles sign in." || len(sv.funcs) != 2 {
+		t.Errorf("unexpected servant %+v", sv)
+	}
+	if len(psr.funcList) != 3 {
+		t.Errorf("got %v funcs, want 3", len(psr.funcList))
+	}
+
+	const fn = "func F {\n    req(\n        id long\n    )\n    rsp(\n        ok bool\n    )\n}\n"
+	for text, want := range map[string]string{
+		"servant A {\n" + fn + "}\nservant B {\n" + fn + "}\n": "line 12: func F is duplicated, it is declared on line 2 already",
+		"servant A {\n" + fn + "}\nservant a {\n" + fn + "}\n": "line 11: servant a is duplicated, servant A is declared on line 1 already",
+		"servant A {\n    struct X {\n    }\n}\n":              "line 2: servant A parse error: unexpected \"struct X\", expect func declarations",
+		"servant A {\n}\n": "line 1: servant A has no funcs",
+		fn + "servant greeter {\n" + strings.Replace(fn, "F", "G", 1) + "}\n": "line 9: servant greeter has the name of the file, which names the servant of the top-level funcs",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+}
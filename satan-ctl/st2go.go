@@ -1,29 +1,49 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"go/format"
 	"io/ioutil"
-	"os/exec"
 	"path"
 	"strings"
+	"time"
 )
 
 var St2Go = &St2GoCommand{}
 
 type St2GoCommand struct {
-	directory string
+	directory   string
+	templateDir string
+	options     st2GoOptions
+}
+
+// st2GoOptions are the st2go flags that change the generated code.
+type st2GoOptions struct {
+	jsonCase stJSONCase
+	http     bool
 }
 
 func (c *St2GoCommand) ParseArgs(args []string) error {
 	fs := flag.NewFlagSet("st2go", flag.ContinueOnError)
 	directory := fs.String("d", "./", "stproto file directory")
+	templateDir := fs.String("t", "", "template directory overriding the built-in go templates")
+	jsonCase := fs.String("json-case", string(stJSONCaseName), "JSON field names: name as in the stproto file, camel or snake")
+	http := fs.Bool("http", false, "also generate a net/http JSON handler for every servant")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
+	var err error
+	if c.options.jsonCase, err = parseJSONCase(*jsonCase); err != nil {
+		fmt.Println(err)
+		return err
+	}
 	c.directory = *directory
+	c.templateDir = *templateDir
+	c.options.http = *http
 	return nil
 }
 
@@ -32,40 +52,42 @@ func (c *St2GoCommand) Description() string {
 		"\n\t\tGenerate Satango service interface dependencies based on stproto files."
 }
 
-func (c *St2GoCommand) Exec() {
+func (c *St2GoCommand) Exec() int {
 	fileList, err := getStProtoFilesPath(c.directory)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return 1
+	}
+
+	tmpl, err := loadGoTemplate(c.templateDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
 	}
 
 	var psrList []*stProtoParser
 	for _, filePath := range fileList {
 		fmt.Printf("parsing %v...\n", path.Base(filePath))
-		psr, err := parseStProtoFile(filePath)
+		psr, err := loadStProtoFile(filePath)
 		if err != nil {
 			fmt.Println(err)
-			return
+			return 1
 		}
-		if err := psr.parseStruct(); err != nil {
-			fmt.Println(err)
-			return
-		}
-		if err := psr.parseFunc(); err != nil {
-			fmt.Println(err)
-			return
+		for _, warning := range psr.warnings {
+			fmt.Printf("warning: %v\n", warning)
 		}
 		psrList = append(psrList, psr)
 	}
 
 	for _, psr := range psrList {
-		if err := psr.toGoFile(); err != nil {
+		if err := psr.toGoFile(tmpl, c.options); err != nil {
 			fmt.Println(err)
-			return
+			return 1
 		}
 	}
 
 	fmt.Println("st2go finish >>>>>>>>>>>>>>>>>>>>>")
+	return 0
 }
 
 var toGoDataTypeStrMap = map[stProtocolType]string{
@@ -79,327 +101,478 @@ var toGoDataTypeStrMap = map[stProtocolType]string{
 	List:   "List",
 	Map:    "Map",
 	Struct: "Struct",
+	// the runtime has no other integer kinds, the sized and unsigned types are
+	// sent as the smallest of int and long that holds them
+	Short:  "Int",
+	UInt:   "Long",
+	ULong:  "Long",
+	Int32:  "Int",
+	Int64:  "Long",
+	UInt32: "Long",
+	UInt64: "Long",
+	// timestamp is Unix time in milliseconds, duration in nanoseconds
+	Timestamp: "Long",
+	Duration:  "Long",
+	// arrays and sets are encoded like lists, a oneof like a struct holding
+	// the field of its variant
+	Array: "List",
+	Set:   "List",
+	Oneof: "Struct",
+}
+
+// toGoWireKindMap maps the types sent as another type to that type, whose Go
+// type is what protocol.StBuffer reads and writes.
+var toGoWireKindMap = map[stProtocolType]stProtocolType{
+	Short:     Int,
+	UInt:      Long,
+	ULong:     Long,
+	Int32:     Int,
+	Int64:     Long,
+	UInt32:    Long,
+	UInt64:    Long,
+	Timestamp: Long,
+	Duration:  Long,
+}
+
+// toGoRangeMap lists the types whose Go values do not all fit their wire
+// encoding, WriteDataBuf fails on values out of range instead of truncating.
+// ulong is sent as a long, so it holds values up to math.MaxInt64 only.
+var toGoRangeMap = map[stProtocolType]*goRange{
+	Int:    {Min: "math.MinInt32", Max: "math.MaxInt32", Wire: "int32"},
+	UInt:   {Max: "math.MaxUint32", Wire: "uint32"},
+	ULong:  {Max: "math.MaxInt64", Wire: "int64"},
+	UInt64: {Max: "math.MaxInt64", Wire: "int64"},
+}
+
+// toGoReadRangeMap lists the types whose wire values do not all fit their Go
+// type, ReadDataBuf rejects values out of range instead of truncating.
+var toGoReadRangeMap = map[stProtocolType]*goRange{
+	Short:  {Min: "math.MinInt16", Max: "math.MaxInt16", Wire: "int16"},
+	Int32:  {Min: "math.MinInt32", Max: "math.MaxInt32", Wire: "int32"},
+	UInt:   {Min: "0", Max: "math.MaxUint32", Wire: "uint32"},
+	UInt32: {Min: "0", Max: "math.MaxUint32", Wire: "uint32"},
+	ULong:  {Min: "0", Wire: "uint64"},
+	UInt64: {Min: "0", Wire: "uint64"},
 }
 
 var toGoDataTypeGoMap = map[stProtocolType]string{
-	Byte:   "byte",
-	Bool:   "bool",
-	Int:    "int",
-	Long:   "int64",
-	Float:  "float32",
-	Double: "float64",
-	String: "string",
-	List:   "[]%v",
-	Map:    "map[%v]%v",
-	Struct: "*%v",
+	Byte:      "byte",
+	Bool:      "bool",
+	Int:       "int",
+	Long:      "int64",
+	Float:     "float32",
+	Double:    "float64",
+	String:    "string",
+	List:      "[]%v",
+	Map:       "map[%v]%v",
+	Struct:    "*%v",
+	Short:     "int16",
+	UInt:      "uint",
+	ULong:     "uint64",
+	Int32:     "int32",
+	Int64:     "int64",
+	UInt32:    "uint32",
+	UInt64:    "uint64",
+	Timestamp: "time.Time",
+	Duration:  "time.Duration",
+	Array:     "[%v]%v",
+	Set:       "map[%v]struct{}",
 }
 
 var toGoDefaultValueMap = map[stProtocolType]string{
-	Byte:   "byte(0)",
-	Bool:   "false",
-	Int:    "0",
-	Long:   "int64(0)",
-	Float:  "float32(0)",
-	Double: "float64(0)",
-	String: "\"\"",
-	List:   "make(%v, 0)",
-	Map:    "make(%v)",
-	Struct: "nil",
+	Byte:      "byte(0)",
+	Bool:      "false",
+	Int:       "0",
+	Long:      "int64(0)",
+	Float:     "float32(0)",
+	Double:    "float64(0)",
+	String:    "\"\"",
+	List:      "make(%v, 0)",
+	Map:       "make(%v)",
+	Struct:    "New%v()",
+	Short:     "int16(0)",
+	UInt:      "uint(0)",
+	ULong:     "uint64(0)",
+	Int32:     "int32(0)",
+	Int64:     "int64(0)",
+	UInt32:    "uint32(0)",
+	UInt64:    "uint64(0)",
+	Timestamp: "time.Time{}",
+	Duration:  "time.Duration(0)",
+	Array:     "%v{}",
+	Set:       "make(%v)",
 }
 
-func (psr *stProtoParser) toGoFile() error {
-	// Header
-	psr.toGoWriteHeader()
-	// struct
-	for _, st := range psr.structMap {
-		psr.tgtFileText += st.toGoWriteStruct()
-		psr.tgtFileText += st.toGoWriteFuncWriteDataBuf()
-		psr.tgtFileText += st.toGoWriteFuncReadDataBuf()
-		psr.tgtFileText += st.toGoWriteFuncNewPerson()
-		psr.tgtFileText += "\n"
+func (psr *stProtoParser) toGoFile(tmpl goTemplate, opts st2GoOptions) error {
+	if err := psr.checkGoNames(); err != nil {
+		return err
+	}
+	fd := psr.toGoFileData(opts)
+	if err := fd.checkJSONNames(); err != nil {
+		return newStCtlError(fmt.Sprintf("%v: %v", psr.filePath, err))
 	}
-	// servant struct
-	// servant func
-
 
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "file", fd); err != nil {
+		return err
+	}
 
 	filePath := path.Join(psr.directory, fmt.Sprintf("%v.stproto.go", psr.servantName))
-	if err := ioutil.WriteFile(filePath, []byte(psr.tgtFileText), 0666); err != nil {
-		return err
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		// keep the unformatted output around so a broken template can be inspected
+		_ = ioutil.WriteFile(filePath, buf.Bytes(), 0666)
+		return newStCtlError(fmt.Sprintf("%v: generated code is invalid: %v", filePath, err))
 	}
-	cmd := exec.Command("go", "fmt", filePath)
-	_ = cmd.Run()
-	return nil
+	return ioutil.WriteFile(filePath, src, 0666)
 }
 
-func (psr *stProtoParser) toGoWriteHeader() {
-	psr.tgtFileText += fmt.Sprintf("package %v\n\n", psr.serverName)
+func (psr *stProtoParser) toGoFileData(opts st2GoOptions) *goFileData {
+	fd := &goFileData{
+		Package:     psr.serverName,
+		ServantName: upperFirstChar(psr.servantName),
+		HTTP:        opts.http,
+	}
+	for _, pc := range psr.constList {
+		fd.Consts = append(fd.Consts, &goConstData{
+			Name:    pc.name,
+			GoName:  pc.goName(),
+			GoType:  toGoDataTypeGoMap[pc.typ],
+			Value:   pc.value,
+			Doc:     pc.docComment,
+			Comment: pc.comment,
+		})
+	}
+	structNames := psr.goStructNames()
+	for _, pa := range psr.aliasList {
+		fd.Aliases = append(fd.Aliases, &goAliasData{
+			Name:    pa.name,
+			GoName:  structNames[pa.name],
+			Type:    newGoType(pa.typ, structNames),
+			Doc:     pa.docComment,
+			Comment: pa.comment,
+		})
+	}
+	for _, pe := range psr.errorList {
+		fd.Errors = append(fd.Errors, &goErrorData{
+			Name:       pe.name,
+			GoName:     pe.goName(),
+			Code:       pe.code,
+			Message:    pe.message,
+			HTTPStatus: pe.httpStatus,
+			Doc:        pe.docComment,
+			Comment:    pe.comment,
+		})
+	}
+	for _, ps := range psr.structList {
+		fd.Structs = append(fd.Structs, ps.toGoStructData(structNames, psr.constMap))
+	}
+	for _, sv := range psr.servantList {
+		sd := &goServantData{
+			Name:        sv.name,
+			ServantName: upperFirstChar(sv.name),
+			Comment:     sv.comment,
+			HTTP:        opts.http,
+			FileServant: fd.ServantName,
+			ErrorName:   fd.ServantName + "Error",
+			Errors:      fd.Errors,
+		}
+		for _, pf := range sv.funcs {
+			fn := psr.toGoFuncData(pf, structNames)
+			fd.Structs = append(fd.Structs, fn.Req)
+			if fn.Rsp != nil {
+				fd.Structs = append(fd.Structs, fn.Rsp)
+			}
+			sd.Funcs = append(sd.Funcs, fn)
+			fd.Funcs = append(fd.Funcs, fn)
+		}
+		fd.Servants = append(fd.Servants, sd)
+	}
+	for _, sd := range fd.Structs {
+		sd.FileServant = fd.ServantName
+	}
 
-	psr.tgtFileText += "import (\n"
-	psr.tgtFileText += "\t\"satanGo/satan/errors\"\n"
-	psr.tgtFileText += "\t\"satanGo/satan/protocol\"\n"
-	psr.tgtFileText += ")\n\n"
+	fd.setJSONNames(opts.jsonCase)
+	fd.Imports = fd.toGoImports()
+	return fd
 }
-func (psr *stProtoParser) toGoWriteServantStruct() {
-	psr.tgtFileText += fmt.Sprintf("package %v\n\n", psr.serverName)
 
-	psr.tgtFileText += "import (\n"
-	psr.tgtFileText += "\t\"satanGo/satan/errors\"\n"
-	psr.tgtFileText += "\t\"satanGo/satan/protocol\"\n"
-	psr.tgtFileText += ")\n\n"
+func (psr *stProtoParser) toGoFuncData(pf *stProtoFunc, structNames map[string]string) *goFuncData {
+	fn := &goFuncData{
+		Name:       pf.name,
+		GoName:     pf.goName(),
+		Req:        pf.req.toGoStructData(structNames, psr.constMap),
+		Idempotent: pf.options.idempotent,
+		Oneway:     pf.options.oneway,
+		Retry:      pf.options.retry,
+		ReqStream:  pf.reqStream,
+		RspStream:  pf.rspStream,
+	}
+	fn.Comment, fn.Deprecated = withDeprecated(pf.comment, pf.filters)
+	if pf.options.timeout > 0 {
+		fn.Timeout = goDuration(pf.options.timeout)
+	}
+	if pf.rsp != nil {
+		fn.Rsp = pf.rsp.toGoStructData(structNames, psr.constMap)
+	}
+	return fn
 }
 
-func (ps *stProtoStruct) toGoWriteStruct() string {
-	var ret string
-	ret += fmt.Sprintf("type %v struct {\n", upperFirstChar(ps.name))
+func (ps *stProtoStruct) toGoStructData(structNames map[string]string, consts map[string]*stProtoConst) *goStructData {
+	sd := &goStructData{Name: ps.name, GoName: structNames[ps.name]}
+	sd.Comment, sd.Deprecated = withDeprecated(ps.comment, ps.filters)
+	for _, pe := range ps.embeds {
+		sd.Embeds = append(sd.Embeds, structNames[pe.name])
+	}
 	for _, pf := range ps.fieldList {
-		ret += fmt.Sprintf(
-			"\t%v %v `json:\"%v\"`\n",
-			upperFirstChar(pf.name),
-			pf.toGoGetDataTypeStr(pf.dataType, pf.subDataTypes),
-			pf.name,
-		)
+		fd := pf.toGoFieldData(structNames)
+		fd.Embedded = pf.embeddedFrom != ""
+		if pf.defaultValue != "" {
+			fd.DefaultValue = goLiteral(fd.Type, pf.defaultValue, consts)
+		}
+		if text, ok := findFilterText(pf.filters, "max_len"); ok {
+			fd.MaxLen = goLength(text, consts)
+		}
+		if pf.typ.dataType == Oneof {
+			// an unset oneof is left out like an optional field, its types
+			// are named after the struct declaring it
+			owner := sd.GoName
+			if fd.Embedded {
+				owner = structNames[pf.embeddedFrom]
+			}
+			fd.Optional = true
+			fd.Oneof = &goOneofData{GoName: owner + fd.GoName}
+			for _, variant := range pf.typ.variants {
+				vd := variant.toGoFieldData(structNames)
+				vd.Wrapper = fd.Oneof.GoName + vd.GoName
+				fd.Oneof.Variants = append(fd.Oneof.Variants, vd)
+			}
+		}
+		sd.Fields = append(sd.Fields, fd)
+	}
+	return sd
+}
+
+func (pf *stProtoField) toGoFieldData(structNames map[string]string) *goFieldData {
+	fd := &goFieldData{
+		Name:     pf.name,
+		GoName:   pf.goName(),
+		Tag:      pf.tag,
+		Type:     newGoType(pf.typ, structNames),
+		Optional: pf.optional,
+		Comment:  pf.comment,
+		JSONName: jsonFilterName(pf.filters),
+	}
+	_, fd.OmitEmpty = findFilter(pf.filters, "omitempty")
+	fd.Doc, fd.Deprecated = withDeprecated(withTimestampNote(pf.docComment, pf.typ), pf.filters)
+	return fd
+}
+
+// withTimestampNote tells in the doc comment of a field holding timestamps
+// that they are sent in milliseconds. The variants of a oneof get their own.
+func withTimestampNote(doc string, t *stProtoType) string {
+	if t.dataType == Oneof {
+		return doc
+	}
+	timestamp := false
+	t.walk(func(t *stProtoType) {
+		if t.dataType == Timestamp {
+			timestamp = true
+		}
+	})
+	if !timestamp {
+		return doc
 	}
-	ret += "}\n"
+	const note = "Timestamps are sent in milliseconds, finer times are truncated."
+	if doc == "" {
+		return note
+	}
+	return doc + "\n\n" + note
+}
 
-	return ret
+// withDeprecated appends the "Deprecated:" paragraph of a deprecated filter
+// to the doc comment and returns it with the deprecation message.
+func withDeprecated(doc string, filters []string) (string, string) {
+	msg, ok := deprecation(filters)
+	if !ok {
+		return doc, ""
+	}
+	if doc == "" {
+		return "Deprecated: " + msg, msg
+	}
+	return doc + "\n\nDeprecated: " + msg, msg
 }
 
-func (ps *stProtoStruct) toGoWriteFuncWriteDataBuf() string {
-	var ret string
-	ret += fmt.Sprintf("func (st *%v) WriteDataBuf(bf *protocol.StBuffer) error {\n", ps.name)
-	// length
-	ret += fmt.Sprintf("\tif err := bf.WriteStructLength(%v); err != nil {\n", len(ps.fieldList))
-	ret += "\t\treturn err"
-	ret += "\t}\n\n"
-
-	// field
-	for tg, pf := range ps.fieldList {
-		// field tag
-		ret += fmt.Sprintf("\tif err := bf.WriteTag(%v); err != nil  {\n", tg)
-		ret += "\t\treturn err\n"
-		ret += "\t}\n"
-
-		// field datatype
-		ret += fmt.Sprintf("\tif err := bf.WriteDataType(protocol.%v); err != nil {\n", toGoDataTypeStrMap[pf.dataType])
-		ret += "\t\treturn err\n"
-		ret += "\t}\n"
-
-		// field dataBuf
-		ret += pf.toGoWriteDataBuf(1, pf.dataType, pf.subDataTypes, "st."+upperFirstChar(pf.name))
-		ret += "\n"
-	}
-
-	ret += "\treturn nil\n"
-	ret += "}\n"
-	return ret
+// goLiteral converts a literal or const name checked by checkValue to a Go
+// expression of type t.
+func goLiteral(t *goType, text string, consts map[string]*stProtoConst) string {
+	pc := consts[text]
+	if pc != nil {
+		text = pc.goName()
+	}
+	if t.Alias == "" && (pc != nil || t.Kind == Bool || t.Kind == Int || t.Kind == String) {
+		return text
+	}
+	return fmt.Sprintf("%v(%v)", t.GoType(), text)
 }
-func (ps *stProtoStruct) toGoWriteFuncReadDataBuf() string {
-	var ret string
-	ret += fmt.Sprintf("func (st *%v) ReadDataBuf(bf *protocol.StBuffer) error {\n", ps.name)
-	// length
-	ret += "\tl, err := bf.ReadStructLength()\n"
-	ret += "\tif err != nil {\n"
-	ret += "\t\treturn err\n"
-	ret += "\t}\n\n"
-
-	// field
-	ret += "\tfor i := byte(0); i < l; i++ {\n"
-	ret += "\t\ttg, err := bf.ReadTag()\n"
-	ret += "\t\tif err != nil {\n"
-	ret += "\t\t\treturn err\n"
-	ret += "\t\t}\n"
-	ret += "\t\tif _, err := bf.ReadDataType(); err != nil {\n"
-	ret += "\t\t\treturn err\n"
-	ret += "\t\t}\n\n"
-
-	ret += "\t\tswitch tg {\n"
-	for tg, pf := range ps.fieldList {
-		ret += fmt.Sprintf("\t\tcase byte(%v):\n", tg)
-		ret += pf.toGoReadDataBuf(1, pf.dataType, pf.subDataTypes, "d")
-		ret += fmt.Sprintf("\t\t\tst.%v = d1\n", upperFirstChar(pf.name))
-	}
-	ret += "\t\t}\n\n"
-	ret += "\t}\n"
-
-	ret += "\treturn nil\n"
-	ret += "}\n"
-	return ret
+
+// goDuration writes d as a Go expression in the largest unit that divides it,
+// e.g. "500 * time.Millisecond".
+func goDuration(d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Hour, "Hour"}, {time.Minute, "Minute"}, {time.Second, "Second"}, {time.Millisecond, "Millisecond"}, {time.Microsecond, "Microsecond"}} {
+		if d%u.d == 0 {
+			if d == u.d {
+				return "time." + u.name
+			}
+			return fmt.Sprintf("%v * time.%v", int64(d/u.d), u.name)
+		}
+	}
+	return fmt.Sprintf("%v * time.Nanosecond", int64(d))
 }
-func (ps *stProtoStruct) toGoWriteFuncNewPerson() string {
-	var ret string
-	stName := upperFirstChar(ps.name)
-	ret += fmt.Sprintf("func New%v() *%v {\n", stName, stName)
-	ret += fmt.Sprintf("\treturn &%v{\n", stName)
-	for _, pf := range ps.fieldList {
-		ret += fmt.Sprintf("\t\t%v: %v,\n", upperFirstChar(pf.name), pf.toGoGetDefaultValue())
+
+// goLength converts a length checked by checkLength to a Go int expression.
+func goLength(text string, consts map[string]*stProtoConst) string {
+	if pc := consts[text]; pc != nil {
+		if toGoDataTypeGoMap[pc.typ] != "int" {
+			return fmt.Sprintf("int(%v)", pc.goName())
+		}
+		return pc.goName()
 	}
-	ret += "\t}\n"
-	ret += "}\n"
-	return ret
+	return text
 }
 
-func (pf *stProtoField) toGoGetDefaultValue() string {
-	if pf.defaultValue != "" {
-		return pf.defaultValue
+func (fd *goFileData) toGoImports() []string {
+	var imports []string
+	if len(fd.Funcs) > 0 {
+		imports = append(imports, "context")
+	}
+	if len(fd.Funcs) > 0 && (len(fd.Errors) > 0 || fd.HTTP) {
+		// the satanGo errors package takes the name errors
+		imports = append(imports, "stderrors errors")
+	}
+	if len(fd.Structs) > 0 {
+		imports = append(imports, "encoding/json")
+	}
+	if len(fd.Funcs) > 0 && fd.HTTP {
+		imports = append(imports, "io", "net/http")
 	}
+	if len(fd.Funcs) > 0 || len(fd.Errors) > 0 || fd.hasField((*goType).NeedsValidate) || fd.hasMaxLen() || fd.hasOneof() {
+		imports = append(imports, "fmt")
+	}
+	if fd.hasField((*goType).hasRange) || fd.hasField((*goType).hasReadRange) {
+		imports = append(imports, "math")
+	}
+	if fd.hasField((*goType).hasTime) || fd.hasAlias((*goType).hasTime) || fd.hasTimeout() || fd.hasRetry() {
+		imports = append(imports, "time")
+	}
+	if fd.hasField((*goType).usesStError) || fd.hasMaxLen() || len(fd.Errors) > 0 {
+		imports = append(imports, "satanGo/satan/errors")
+	}
+	if len(fd.Structs) > 0 {
+		imports = append(imports, "satanGo/satan/protocol")
+	}
+	return imports
+}
 
-	switch pf.dataType {
-	case Byte, Bool, Int, Long, Float, Double, String:
-		return toGoDefaultValueMap[pf.dataType]
-	case List, Map:
-		return fmt.Sprintf(toGoDefaultValueMap[pf.dataType], pf.toGoGetDataTypeStr(pf.dataType, pf.subDataTypes))
-	default:
-		return fmt.Sprintf(toGoDefaultValueMap[pf.dataType])
+func (fd *goFileData) hasTimeout() bool {
+	for _, fn := range fd.Funcs {
+		if fn.Timeout != "" {
+			return true
+		}
 	}
+	return false
 }
-func (pf *stProtoField) toGoGetDataTypeStr(dt stProtocolType, sDts []stProtocolType) string {
-	switch dt {
-	case Byte, Bool, Int, Long, Float, Double, String:
-		return toGoDataTypeGoMap[dt]
-	case List:
-		return fmt.Sprintf(toGoDataTypeGoMap[dt], pf.toGoGetDataTypeStr(sDts[0], sDts[1:]))
-	case Map:
-		return fmt.Sprintf(toGoDataTypeGoMap[dt], pf.toGoGetDataTypeStr(sDts[0], []stProtocolType{}), pf.toGoGetDataTypeStr(sDts[1], sDts[2:]))
-	default:
-		return fmt.Sprintf(toGoDataTypeGoMap[dt], upperFirstChar(pf.subStructName))
+
+func (fd *goFileData) hasRetry() bool {
+	for _, fn := range fd.Funcs {
+		if fn.Retry > 0 && !fn.Oneway {
+			return true
+		}
 	}
+	return false
 }
-func (pf *stProtoField) toGoWriteDataBuf(tbIdx int, tp stProtocolType, sTps []stProtocolType, forStr string) string {
-	var ret string
-	var tb string
-	for i:=0; i<tbIdx; i++ {
-		tb += "\t"
-	}
-
-	switch tp {
-	case Byte, Bool, Int, Long, Float, Double, String:
-		ret += fmt.Sprintf("%vif err := bf.WriteDataBuf(protocol.%v, %v); err != nil {\n", tb, toGoDataTypeStrMap[tp], forStr)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-	case List:
-		// elem dataType
-		ret += fmt.Sprintf("%vif err := bf.WriteDataType(protocol.%v); err != nil {\n", tb, toGoDataTypeStrMap[sTps[0]])
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// length
-		ret += fmt.Sprintf("%vif err := bf.WriteLength(len(%v)); err != nil {\n", tb, forStr)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// for [dataBuf]...
-		if sTps[0] == Byte {
-			ret += fmt.Sprintf("%vif err := bf.WriteBytes(%v); err != nil {\n", tb, forStr)
-			ret += fmt.Sprintf("\t%vreturn err\n", tb)
-			ret += fmt.Sprintf("%v}\n", tb)
-		} else {
-			ret += fmt.Sprintf("%vfor _, e%v := range %v {\n", tb, tbIdx, forStr)
-			ret += pf.toGoWriteDataBuf(tbIdx+1, sTps[0], sTps[1:], fmt.Sprintf("e%v", tbIdx))
-			ret += fmt.Sprintf("%v}\n", tb)
+
+// hasField reports whether the type of any field or oneof variant satisfies f.
+func (fd *goFileData) hasField(f func(t *goType) bool) bool {
+	for _, sd := range fd.Structs {
+		for _, fd := range sd.Fields {
+			if f(fd.Type) {
+				return true
+			}
+			if fd.Oneof == nil {
+				continue
+			}
+			for _, vd := range fd.Oneof.Variants {
+				if f(vd.Type) {
+					return true
+				}
+			}
 		}
+	}
+	return false
+}
 
-	case Map:
-		// key dataType
-		ret += fmt.Sprintf("%vif err := bf.WriteDataType(protocol.%v); err != nil {\n", tb, toGoDataTypeStrMap[sTps[0]])
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// value dataType
-		ret += fmt.Sprintf("%vif err := bf.WriteDataType(protocol.%v); err != nil {\n", tb, toGoDataTypeStrMap[sTps[1]])
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// length
-		ret += fmt.Sprintf("%vif err := bf.WriteLength(len(%v)); err != nil {\n", tb, forStr)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// for [dataBuf]...
-		ret += fmt.Sprintf("%vfor k%v, v%v := range %v {\n", tb, tbIdx, tbIdx, forStr)
-		ret += pf.toGoWriteDataBuf(tbIdx+1, sTps[0], []stProtocolType{}, fmt.Sprintf("k%v", tbIdx))
-		ret += pf.toGoWriteDataBuf(tbIdx+1, sTps[1], sTps[2:], fmt.Sprintf("v%v", tbIdx))
-		ret += fmt.Sprintf("%v}\n", tb)
-	case Struct:
-		ret += fmt.Sprintf("%vif err := %v.WriteDataBuf(bf); err != nil {\n", tb, forStr)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
+func (fd *goFileData) hasAlias(f func(t *goType) bool) bool {
+	for _, ad := range fd.Aliases {
+		if f(ad.Type) {
+			return true
+		}
 	}
+	return false
+}
 
-	return ret
+func (fd *goFileData) hasOneof() bool {
+	for _, sd := range fd.Structs {
+		if sd.HasOneof() {
+			return true
+		}
+	}
+	return false
 }
-func (pf *stProtoField) toGoReadDataBuf(tbIdx int, tp stProtocolType, sTps []stProtocolType, forStr string) string {
-	var ret string
-	var tb string
-	for i:=0; i<tbIdx+2; i++ {
-		tb += "\t"
-	}
-	varName := fmt.Sprintf("%v%v", forStr, tbIdx)
-
-	switch tp {
-	case Byte, Bool, Int, Long, Float, Double, String:
-		ret += fmt.Sprintf("%v_%v, err := bf.ReadDataBuf(protocol.%v)\n", tb, varName, toGoDataTypeStrMap[tp])
-		ret += fmt.Sprintf("%vif err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		ret += fmt.Sprintf("%v%v, ok := _%v.(%v)\n", tb, varName, varName, toGoDataTypeGoMap[tp])
-		ret += fmt.Sprintf("%vif !ok {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn errors.NewStError(1004)\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-	case List:
 
-		// elem dataType
-		ret += fmt.Sprintf("%vif _, err := bf.ReadDataType(); err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// length
-		ret += fmt.Sprintf("%vl%v, err := bf.ReadLength()\n", tb, tbIdx)
-		ret += fmt.Sprintf("%vif err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// for [dataBuf]...
-		if sTps[0] == Byte {
-			ret += fmt.Sprintf("%v%v, err := bf.ReadBytes(l%v)\n", tb, varName, tbIdx)
-			ret += fmt.Sprintf("%vif err != nil {\n", tb)
-			ret += fmt.Sprintf("\t%vreturn err\n", tb)
-			ret += fmt.Sprintf("%v}\n", tb)
-		} else {
-			// init
-			ret += fmt.Sprintf("%v%v := make(%v, l%v)\n", tb, varName, pf.toGoGetDataTypeStr(tp, sTps), tbIdx)
-			ret += fmt.Sprintf("%vfor i%v:=0; i%v<l%v; i%v++ {\n", tb, tbIdx, tbIdx, tbIdx, tbIdx)
-			ret += pf.toGoReadDataBuf(tbIdx+1, sTps[0], sTps[1:], "e")
-			ret += fmt.Sprintf("\t%v%v[i%v] = e%v\n", tb, varName, tbIdx, tbIdx+1)
-			ret += fmt.Sprintf("%v}\n", tb)
+func (fd *goFileData) hasMaxLen() bool {
+	for _, sd := range fd.Structs {
+		for _, fd := range sd.Fields {
+			if fd.MaxLen != "" {
+				return true
+			}
 		}
+	}
+	return false
+}
+
+// newGoType converts a parsed type into the type the templates work with,
+// struct and alias names become Go names.
+func newGoType(pt *stProtoType, structNames map[string]string) *goType {
+	t := &goType{Kind: pt.dataType}
+	if pt.aliasName != "" {
+		t.Alias = structNames[pt.aliasName]
+	}
+	switch pt.dataType {
+	case Bytes:
+		// bytes is a named []byte, it is encoded like a list of byte
+		t.Kind, t.Elem = List, &goType{Kind: Byte}
+	case List:
+		t.Elem = newGoType(pt.elem, structNames)
+	case Array:
+		t.Elem, t.Len = newGoType(pt.elem, structNames), pt.size
+	case Set:
+		t.Elem = newGoType(pt.elem, structNames)
+		t.Elem.ByValue = t.Elem.IsStruct()
 	case Map:
-		// init
-		ret += fmt.Sprintf("%v%v := make(%v)\n", tb, varName, pf.toGoGetDataTypeStr(tp, sTps))
-		// key dataType
-		ret += fmt.Sprintf("%vif _, err := bf.ReadDataType(); err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// value dataType
-		ret += fmt.Sprintf("%vif _, err := bf.ReadDataType(); err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// length
-		ret += fmt.Sprintf("%vl%v, err := bf.ReadLength()\n", tb, tbIdx)
-		ret += fmt.Sprintf("%vif err != nil {\n", tb)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
-		// for [dataBuf]...
-		ret += fmt.Sprintf("%vfor i%v:=0; i%v<l%v; i%v++ {\n", tb, tbIdx, tbIdx, tbIdx, tbIdx)
-		ret += pf.toGoReadDataBuf(tbIdx+1, sTps[0], []stProtocolType{}, "k")
-		ret += pf.toGoReadDataBuf(tbIdx+1, sTps[1], sTps[2:], "v")
-		ret += fmt.Sprintf("\t%v%v[k%v] = v%v\n", tb, varName, tbIdx+1, tbIdx+1)
-		ret += fmt.Sprintf("%v}\n", tb)
+		t.Key = newGoType(pt.key, structNames)
+		// struct keys and set elements are compared by value
+		t.Key.ByValue = t.Key.IsStruct()
+		t.Value = newGoType(pt.value, structNames)
 	case Struct:
-		// init
-		ret += fmt.Sprintf("%v%v := New%v()\n", tb, varName, upperFirstChar(pf.subStructName))
-		// ReadDataBuf
-		ret += fmt.Sprintf("%vif err := %v.ReadDataBuf(bf); err != nil {\n", tb, varName)
-		ret += fmt.Sprintf("\t%vreturn err\n", tb)
-		ret += fmt.Sprintf("%v}\n", tb)
+		t.StructName = structNames[pt.structName]
 	}
-
-	return ret
+	return t
 }
 
 func upperFirstChar(s string) string {
-	return strings.ToUpper(s)[0:1]+s[1:]
-}
\ No newline at end of file
+	return strings.ToUpper(s)[0:1] + s[1:]
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// stGoMethodNames are the methods generated on every struct.
+var stGoMethodNames = []string{"WriteDataBuf", "ReadDataBuf", "Validate", "UnmarshalJSON"}
+
+// goName returns the go_name filter argument if there is one, else the name
+// with its first char in upper case. Go keywords need no escaping that way,
+// "type" becomes Type, but names may collide, which checkGoNames reports.
+func goName(name string, filters []string) string {
+	if args, ok := findFilter(filters, "go_name"); ok && len(args) == 1 {
+		return args[0]
+	}
+	return upperFirstChar(name)
+}
+
+func (pf *stProtoField) goName() string {
+	return goName(pf.name, pf.filters)
+}
+
+func (pf *stProtoFunc) goName() string {
+	return goName(pf.name, pf.filters)
+}
+
+func (pc *stProtoConst) goName() string {
+	return goName(pc.name, pc.filters)
+}
+
+// goName of an error is its name prefixed with Err unless set by go_name.
+func (pe *stProtoError) goName() string {
+	if args, ok := findFilter(pe.filters, "go_name"); ok && len(args) == 1 {
+		return args[0]
+	}
+	return "Err" + upperFirstChar(pe.name)
+}
+
+// goStructNames maps every struct name, including the req and rsp structs of
+// funcs, and every type alias name to its Go identifier.
+func (psr *stProtoParser) goStructNames() map[string]string {
+	names := make(map[string]string)
+	for _, pa := range psr.aliasList {
+		names[pa.name] = goName(pa.name, pa.filters)
+	}
+	for _, ps := range psr.structList {
+		names[ps.name] = goName(ps.name, ps.filters)
+	}
+	for _, pf := range psr.funcList {
+		names[pf.req.name] = pf.goName() + "Req"
+		if pf.rsp != nil {
+			names[pf.rsp.name] = pf.goName() + "Rsp"
+		}
+	}
+	return names
+}
+
+// stGoNameProblem is a generated Go name that would not compile.
+type stGoNameProblem struct {
+	line int
+	msg  string
+}
+
+// checkGoNames returns an error listing every Go name problem of the file.
+func (psr *stProtoParser) checkGoNames() error {
+	var errs []string
+	for _, p := range psr.goNameProblems() {
+		errs = append(errs, fmt.Sprintf("line %v: %v", p.line, p.msg))
+	}
+	if len(errs) > 0 {
+		return newStCtlError(fmt.Sprintf("%v: %v", psr.filePath, strings.Join(errs, "\n")))
+	}
+	return nil
+}
+
+// goNameProblems checks the identifiers the go templates declare: the package,
+// the consts and type aliases, the struct types with their constructors,
+// fields and methods, the oneof interfaces with their variant types, and the
+// servant interface with its methods and dispatch func.
+func (psr *stProtoParser) goNameProblems() []*stGoNameProblem {
+	var problems []*stGoNameProblem
+	report := func(line int, format string, a ...interface{}) {
+		problems = append(problems, &stGoNameProblem{line: line, msg: fmt.Sprintf(format, a...)})
+	}
+	checkFilter := func(line int, what string, filters []string) {
+		if args, ok := findFilter(filters, "go_name"); ok && (len(args) != 1 || !token.IsIdentifier(args[0]) || !token.IsExported(args[0])) {
+			report(line, "%v: go_name(%v) must be one exported Go identifier", what, strings.Join(args, ", "))
+		}
+	}
+
+	if !token.IsIdentifier(psr.serverName) {
+		report(1, "package name \"%v\" taken from the directory is not a valid Go identifier", psr.serverName)
+	}
+	if servantName := upperFirstChar(psr.servantName); (len(psr.funcList) > 0 || len(psr.errorList) > 0) && !token.IsIdentifier(servantName) {
+		report(1, "servant name \"%v\" taken from the file name is not a valid Go identifier", servantName)
+	}
+
+	// package scope: consts, errors, aliases, types, constructors, the servant
+	// and its client
+	pkgNames := make(map[string]string)
+	declare := func(line int, what string, name string) {
+		if other, ok := pkgNames[name]; ok {
+			report(line, "%v: Go name %v collides with %v, rename one of them with go_name(...)", what, name, other)
+			return
+		}
+		pkgNames[name] = fmt.Sprintf("%v (line %v)", what, line)
+	}
+	for _, sv := range psr.servantList {
+		servantName := upperFirstChar(sv.name)
+		declare(sv.line, "servant interface", servantName+"Servant")
+		declare(sv.line, "servant dispatch func", "Dispatch"+servantName)
+		declare(sv.line, "client", servantName+"Client")
+		declare(sv.line, "client constructor", "New"+servantName+"Client")
+		declare(sv.line, "client invoker", servantName+"Invoker")
+		declare(sv.line, "HTTP handler", servantName+"HTTPHandler")
+		declare(sv.line, "HTTP handler constructor", "New"+servantName+"HTTPHandler")
+		if sv.hasStreamFunc() {
+			declare(sv.line, "stream", servantName+"Stream")
+			declare(sv.line, "stream dispatch func", "Dispatch"+servantName+"Stream")
+		}
+		for _, pf := range sv.funcs {
+			if pf.reqStream || pf.rspStream {
+				declare(pf.line, "stream of func "+pf.name, pf.goName()+"Stream")
+			}
+		}
+		if sv.hasDeprecatedFunc() {
+			declare(sv.line, "servant deprecation observer", servantName+"DeprecationObserver")
+		}
+	}
+
+	if len(psr.errorList) > 0 {
+		servantName := upperFirstChar(psr.servantName)
+		declare(1, "error type", servantName+"Error")
+		declare(1, "error lookup func", servantName+"ErrorOf")
+	}
+	for _, pe := range psr.errorList {
+		what := "error " + pe.name
+		checkFilter(pe.line, what, pe.filters)
+		declare(pe.line, what, pe.goName())
+	}
+
+	for _, pc := range psr.constList {
+		what := "const " + pc.name
+		checkFilter(pc.line, what, pc.filters)
+		gName := pc.goName()
+		if _, ok := findFilter(pc.filters, "go_name"); !ok && !token.IsExported(gName) {
+			report(pc.line, "%v: Go name %v is not exported, set go_name(...)", what, gName)
+		}
+		declare(pc.line, what, gName)
+	}
+
+	structNames := psr.goStructNames()
+	for _, pa := range psr.aliasList {
+		what := "type " + pa.name
+		checkFilter(pa.line, what, pa.filters)
+		if _, ok := findFilter(pa.filters, "go_name"); !ok && !token.IsExported(structNames[pa.name]) {
+			report(pa.line, "%v: Go name %v is not exported, set go_name(...)", what, structNames[pa.name])
+		}
+		declare(pa.line, what, structNames[pa.name])
+	}
+	for _, ps := range psr.structList {
+		checkFilter(ps.line, "struct "+ps.name, ps.filters)
+	}
+	for _, ps := range psr.allStructs() {
+		gName := structNames[ps.name]
+		if _, ok := findFilter(ps.filters, "go_name"); !ok && !token.IsExported(gName) {
+			report(ps.line, "struct %v: Go name %v is not exported, set go_name(...)", ps.name, gName)
+		}
+		declare(ps.line, "struct "+ps.name, gName)
+		declare(ps.line, "constructor of struct "+ps.name, "New"+gName)
+	}
+
+	// struct scope: fields, embedded structs and generated methods. Fields of
+	// embedded structs are promoted, so they share the scope; their own
+	// problems are reported for the struct declaring them.
+	for _, ps := range psr.allStructs() {
+		embedNames := make(map[string]string)
+		var addEmbeds func(ps *stProtoStruct)
+		addEmbeds = func(ps *stProtoStruct) {
+			for _, pe := range ps.embeds {
+				embedNames[structNames[pe.name]] = pe.name
+				addEmbeds(psr.structMap[pe.name])
+			}
+		}
+		addEmbeds(ps)
+
+		fieldNames := make(map[string]*stProtoField)
+		for _, pf := range ps.fieldList {
+			what := fmt.Sprintf("field %v.%v", ps.name, pf.name)
+			inherited := pf.embeddedFrom != ""
+			if !inherited {
+				checkFilter(pf.line, what, pf.filters)
+			}
+			gName := pf.goName()
+			_, hasGoName := findFilter(pf.filters, "go_name")
+			switch other := fieldNames[gName]; {
+			case !inherited && !hasGoName && !token.IsExported(gName):
+				report(pf.line, "%v: Go name %v is not exported, set go_name(...)", what, gName)
+			case other != nil && inherited && other.embeddedFrom == pf.embeddedFrom:
+				// reported for the struct declaring both
+			case other != nil:
+				report(pf.line, "%v: Go name %v collides with field %v.%v (line %v), rename one of them with go_name(...)", what, gName, ps.name, other.name, other.line)
+			case embedNames[gName] != "":
+				report(pf.line, "%v: Go name %v collides with embedded struct %v, set go_name(...)", what, gName, embedNames[gName])
+			case !inherited && isGoMethodName(gName):
+				report(pf.line, "%v: Go name %v collides with the generated %v method, set go_name(...)", what, gName, gName)
+			}
+			fieldNames[gName] = pf
+
+			if pf.typ.dataType == Oneof && !inherited {
+				oneofName := structNames[ps.name] + gName
+				declare(pf.line, "oneof "+ps.name+"."+pf.name, oneofName)
+				variantNames := make(map[string]*stProtoField)
+				for _, variant := range pf.typ.variants {
+					what := fmt.Sprintf("variant %v.%v.%v", ps.name, pf.name, variant.name)
+					checkFilter(variant.line, what, variant.filters)
+					vName := variant.goName()
+					_, hasGoName := findFilter(variant.filters, "go_name")
+					switch other := variantNames[vName]; {
+					case !hasGoName && !token.IsExported(vName):
+						report(variant.line, "%v: Go name %v is not exported, set go_name(...)", what, vName)
+					case other != nil:
+						report(variant.line, "%v: Go name %v collides with variant %v (line %v), rename one of them with go_name(...)", what, vName, other.name, other.line)
+						continue
+					}
+					variantNames[vName] = variant
+					declare(variant.line, what, oneofName+vName)
+				}
+			}
+		}
+	}
+
+	// servant scope: methods
+	for _, sv := range psr.servantList {
+		methodNames := make(map[string]*stProtoFunc)
+		for _, pf := range sv.funcs {
+			what := "func " + pf.name
+			checkFilter(pf.line, what, pf.filters)
+			gName := pf.goName()
+			if other := methodNames[gName]; other != nil {
+				report(pf.line, "%v: Go name %v collides with func %v (line %v), rename one of them with go_name(...)", what, gName, other.name, other.line)
+			}
+			methodNames[gName] = pf
+		}
+	}
+
+	return problems
+}
+
+func isGoMethodName(name string) bool {
+	for _, m := range stGoMethodNames {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// goTemplateFS holds the built-in st2go templates. Every file defines one or
+// more named templates; generation starts from the "file" template.
+//
+//go:embed template/go/*.tmpl
+var goTemplateFS embed.FS
+
+type goTemplate = *template.Template
+
+var goTemplateFuncMap = template.FuncMap{
+	"upperFirst": upperFirstChar,
+	"goDoc":      goDoc,
+	"goImport":   goImport,
+}
+
+// loadGoTemplate parses the built-in templates and then every *.tmpl file of
+// overrideDir, so that a {{define}} there replaces the built-in one.
+func loadGoTemplate(overrideDir string) (goTemplate, error) {
+	tmpl, err := template.New("st2go").Funcs(goTemplateFuncMap).ParseFS(goTemplateFS, "template/go/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir == "" {
+		return tmpl, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(overrideDir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, newStCtlError(fmt.Sprintf("no *.tmpl file found in template directory %v", overrideDir))
+	}
+	return tmpl.ParseFiles(files...)
+}
+
+// goDoc turns a possibly multi-line comment into "//" comment lines.
+func goDoc(comment string) string {
+	var lines []string
+	for _, line := range strings.Split(comment, "\n") {
+		lines = append(lines, strings.TrimRight("// "+line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// goImport writes an import spec, an Imports entry "name path" imports path
+// under name.
+func goImport(spec string) string {
+	if i := strings.IndexByte(spec, ' '); i >= 0 {
+		return fmt.Sprintf("%v %q", spec[:i], spec[i+1:])
+	}
+	return fmt.Sprintf("%q", spec)
+}
+
+// goFileData is the root value handed to the "file" template. ServantName is
+// the servant named after the file, it also names the error type; Funcs lists
+// the funcs of all Servants. HTTP is set by st2go -http.
+type goFileData struct {
+	Package     string
+	ServantName string
+	HTTP        bool
+	Imports     []string
+	Consts      []*goConstData
+	Aliases     []*goAliasData
+	Errors      []*goErrorData
+	Structs     []*goStructData
+	Funcs       []*goFuncData
+	Servants    []*goServantData
+}
+
+// goServantData is handed to the "servant" template. Name is the servant as
+// declared, or the file name for the servant of the file, and routes its HTTP
+// requests. ErrorName names the error type of the file the declared Errors
+// share and FileServant the servant of the file, which also names the HTTP
+// helpers.
+type goServantData struct {
+	Name        string
+	ServantName string
+	Comment     string
+	HTTP        bool
+	FileServant string
+	ErrorName   string
+	Errors      []*goErrorData
+	Funcs       []*goFuncData
+}
+
+// goConstData holds the Go literal of a const in Value.
+type goConstData struct {
+	Name    string
+	GoName  string
+	GoType  string
+	Value   string
+	Doc     string
+	Comment string
+}
+
+// goAliasData declares a type alias as a Go named type of Type.
+type goAliasData struct {
+	Name    string
+	GoName  string
+	Type    *goType
+	Doc     string
+	Comment string
+}
+
+// goErrorData is a declared error, GoName names its Go variable. HTTPStatus
+// is 0 unless set by the http filter.
+type goErrorData struct {
+	Name       string
+	GoName     string
+	Code       int
+	Message    string
+	HTTPStatus int
+	Doc        string
+	Comment    string
+}
+
+// goStructData lists every field the codec handles in Fields, including the
+// Embedded ones promoted from the structs named in Embeds.
+//
+// Structs, fields and funcs marked deprecated carry the message in Deprecated,
+// their Comment or Doc already ends with the "Deprecated:" paragraph.
+type goStructData struct {
+	Name       string
+	GoName     string
+	Comment    string
+	Deprecated string
+	Embeds     []string
+	Fields     []*goFieldData
+	// FileServant is the servant of the file, it names the helper skipping
+	// the fields ReadDataBuf does not know
+	FileServant string
+}
+
+// OwnFields returns the fields declared in the Go struct itself.
+func (sd *goStructData) OwnFields() []*goFieldData {
+	var fields []*goFieldData
+	for _, fd := range sd.Fields {
+		if !fd.Embedded {
+			fields = append(fields, fd)
+		}
+	}
+	return fields
+}
+
+// HasOneof reports whether the struct holds a oneof.
+func (sd *goStructData) HasOneof() bool {
+	for _, fd := range sd.Fields {
+		if fd.Oneof != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOptional reports whether the struct length written by WriteDataBuf
+// depends on which optional fields are set.
+func (sd *goStructData) HasOptional() bool {
+	return sd.RequiredCount() < len(sd.Fields)
+}
+
+func (sd *goStructData) RequiredCount() int {
+	n := 0
+	for _, fd := range sd.Fields {
+		if !fd.Optional {
+			n++
+		}
+	}
+	return n
+}
+
+// goFieldData carries the comment lines above a field as Doc and the one
+// after it as Comment.
+//
+// A oneof field carries Oneof; its variants are fields again, wrapped in the
+// Wrapper type.
+//
+// DefaultValue and MaxLen are Go expressions set by the default and max_len
+// filters. JSONName is set by the json filter or the JSON case of st2go.
+type goFieldData struct {
+	Name     string
+	GoName   string
+	Tag      int
+	Type     *goType
+	Optional bool
+	Doc      string
+	Comment  string
+	Oneof    *goOneofData
+	Wrapper  string
+	Embedded bool
+
+	Deprecated   string
+	DefaultValue string
+	MaxLen       string
+
+	JSONName  string
+	OmitEmpty bool
+}
+
+// JSONTag is the value of the json struct tag, optional fields are left out
+// while unset.
+func (fd *goFieldData) JSONTag() string {
+	if fd.JSONName != "-" && (fd.Optional || fd.OmitEmpty) {
+		return fd.JSONName + ",omitempty"
+	}
+	return fd.JSONName
+}
+
+// goOneofData is the Go interface of a oneof field that the Wrapper types of
+// its variants implement.
+type goOneofData struct {
+	GoName   string
+	Variants []*goFieldData
+}
+
+// IsPointer reports whether the field is optional and its type has no nil
+// value of its own, so that the Go field is a pointer, nil meaning not sent.
+func (fd *goFieldData) IsPointer() bool {
+	return fd.Optional && (fd.Type.IsBase() || fd.Type.IsArray())
+}
+
+func (fd *goFieldData) GoType() string {
+	if fd.Oneof != nil {
+		return fd.Oneof.GoName
+	}
+	if fd.IsPointer() {
+		return "*" + fd.Type.GoType()
+	}
+	return fd.Type.GoType()
+}
+
+func (fd *goFieldData) Default() string {
+	if fd.Optional {
+		return "nil"
+	}
+	if fd.DefaultValue != "" {
+		return fd.DefaultValue
+	}
+	return fd.Type.Default()
+}
+
+// NeedsValidate reports whether Validate checks the field.
+func (fd *goFieldData) NeedsValidate() bool {
+	return fd.MaxLen != "" || fd.Oneof != nil || fd.Type.NeedsValidate()
+}
+
+// Codec starts the "writeValue" recursion for the field of the receiver st.
+func (fd *goFieldData) Codec() *goValue {
+	return fd.codec("st")
+}
+
+// VariantCodec starts the "writeValue" recursion for a oneof variant held by
+// the wrapper v.
+func (fd *goFieldData) VariantCodec() *goValue {
+	return fd.codec("v")
+}
+
+func (fd *goFieldData) codec(recv string) *goValue {
+	v := fd.Type.Codec(recv + "." + fd.GoName)
+	if fd.IsPointer() {
+		v.Var = "*" + v.Var
+	}
+	v.Field = fd.Name
+	v.Optional = fd.Optional
+	return v
+}
+
+// goFuncData carries the call policy of the func: Timeout is a Go duration
+// expression or empty, Retry the number of retries of an Idempotent func, and
+// a Oneway func has no Rsp. ReqStream and RspStream mark a req or rsp sent as
+// a sequence of messages.
+type goFuncData struct {
+	Name       string
+	GoName     string
+	Comment    string
+	Deprecated string
+	Req        *goStructData
+	Rsp        *goStructData
+
+	Timeout    string
+	Idempotent bool
+	Oneway     bool
+	Retry      int
+
+	ReqStream bool
+	RspStream bool
+}
+
+// IsStream reports whether the func is called over a stream, it is dispatched
+// by the stream dispatcher then.
+func (fn *goFuncData) IsStream() bool {
+	return fn.ReqStream || fn.RspStream
+}
+
+// HasStream reports whether any func is called over a stream.
+func (sd *goServantData) HasStream() bool {
+	for _, fn := range sd.Funcs {
+		if fn.IsStream() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRetry reports whether any func is retried, the client waits between
+// attempts and asks its invoker which failures to retry then.
+func (sd *goServantData) HasRetry() bool {
+	for _, fn := range sd.Funcs {
+		if fn.Retry > 0 && !fn.Oneway {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDeprecated reports whether the servant gets a deprecation observer.
+func (sd *goServantData) HasDeprecated() bool {
+	for _, fn := range sd.Funcs {
+		if fn.Deprecated != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// goType is a stproto data type as a tree: lists, arrays and sets carry Elem,
+// arrays also Len, maps carry Key and Value, structs carry StructName. A
+// struct with ByValue set is a map key or set element held as a value instead
+// of a pointer. A type declared with a type alias carries the Go name of the
+// alias in Alias, values are converted to the aliased Go type for the wire.
+type goType struct {
+	Kind       stProtocolType
+	Elem       *goType
+	Len        int
+	Key        *goType
+	Value      *goType
+	StructName string
+	ByValue    bool
+	Alias      string
+}
+
+func (t *goType) IsBase() bool {
+	switch t.Kind {
+	case Byte, Bool, Int, Long, Float, Double, String,
+		Short, UInt, ULong, Int32, Int64, UInt32, UInt64, Timestamp, Duration:
+		return true
+	}
+	return false
+}
+func (t *goType) IsByte() bool   { return t.Kind == Byte }
+func (t *goType) IsList() bool   { return t.Kind == List }
+func (t *goType) IsArray() bool  { return t.Kind == Array }
+func (t *goType) IsSet() bool    { return t.Kind == Set }
+func (t *goType) IsMap() bool    { return t.Kind == Map }
+func (t *goType) IsStruct() bool { return t.Kind == Struct }
+
+func (t *goType) IsTimestamp() bool { return t.Kind == Timestamp }
+
+// Proto is the name of the matching satanGo protocol.DataType constant.
+func (t *goType) Proto() string {
+	return toGoDataTypeStrMap[t.Kind]
+}
+
+func (t *goType) GoType() string {
+	if t.Alias != "" {
+		return t.Alias
+	}
+	switch t.Kind {
+	case List, Set:
+		return fmt.Sprintf(toGoDataTypeGoMap[t.Kind], t.Elem.GoType())
+	case Array:
+		return fmt.Sprintf(toGoDataTypeGoMap[t.Kind], t.Len, t.Elem.GoType())
+	case Map:
+		return fmt.Sprintf(toGoDataTypeGoMap[t.Kind], t.Key.GoType(), t.Value.GoType())
+	case Struct:
+		if t.ByValue {
+			return t.StructName
+		}
+		return fmt.Sprintf(toGoDataTypeGoMap[t.Kind], t.StructName)
+	default:
+		return toGoDataTypeGoMap[t.Kind]
+	}
+}
+
+func (t *goType) Default() string {
+	switch {
+	case t.Kind == List || t.Kind == Map || t.Kind == Array || t.Kind == Set:
+		return fmt.Sprintf(toGoDefaultValueMap[t.Kind], t.GoType())
+	case t.Kind == Struct:
+		// nested structs start from their own defaults
+		return fmt.Sprintf(toGoDefaultValueMap[t.Kind], t.StructName)
+	case t.Alias == "":
+		return toGoDefaultValueMap[t.Kind]
+	case t.Kind == Bool:
+		return t.Alias + "(false)"
+	case t.Kind == String:
+		return t.Alias + "(\"\")"
+	case t.Kind == Timestamp:
+		return t.Alias + "{}"
+	default:
+		return t.Alias + "(0)"
+	}
+}
+
+// WireGoType is the Go type protocol.StBuffer uses for the wire encoding of
+// t, it differs from GoType for the fixed-width types only.
+func (t *goType) WireGoType() string {
+	if wire, ok := toGoWireKindMap[t.Kind]; ok {
+		return toGoDataTypeGoMap[wire]
+	}
+	return t.unaliased().GoType()
+}
+
+// unaliased returns t without its alias.
+func (t *goType) unaliased() *goType {
+	if t.Alias == "" {
+		return t
+	}
+	u := *t
+	u.Alias = ""
+	return &u
+}
+
+// Underlying converts v of an alias type to the aliased Go type, so that the
+// methods of time.Time can be called on it.
+func (t *goType) Underlying(v string) string {
+	if t.Alias == "" {
+		return v
+	}
+	return fmt.Sprintf("%v(%v)", t.unaliased().GoType(), v)
+}
+
+// ToWire converts v to WireGoType.
+func (t *goType) ToWire(v string) string {
+	if t.Alias != "" {
+		return t.unaliased().ToWire(t.Underlying(v))
+	}
+	if t.IsTimestamp() {
+		v = goParen(v)
+		return fmt.Sprintf("%v.Unix()*1000 + int64(%v.Nanosecond()/1e6)", v, v)
+	}
+	if wire := t.WireGoType(); wire != t.GoType() {
+		return fmt.Sprintf("%v(%v)", wire, v)
+	}
+	return v
+}
+
+// FromWire converts v of WireGoType back to GoType.
+func (t *goType) FromWire(v string) string {
+	if t.IsTimestamp() {
+		v = fmt.Sprintf("time.Unix(%v/1000, %v%%1000*1e6).UTC()", v, v)
+		if t.Alias == "" {
+			return v
+		}
+	}
+	return fmt.Sprintf("%v(%v)", t.GoType(), v)
+}
+
+// Range is the value range checked before encoding, nil if every Go value
+// fits the wire encoding.
+func (t *goType) Range() *goRange {
+	return toGoRangeMap[t.Kind]
+}
+
+// ReadRange is the value range checked after decoding, nil if every wire
+// value fits the Go type.
+func (t *goType) ReadRange() *goRange {
+	return toGoReadRangeMap[t.Kind]
+}
+
+// goRange bounds a value to the Wire integer type, Min or Max is empty if
+// the value cannot exceed it.
+type goRange struct {
+	Min  string
+	Max  string
+	Wire string
+}
+
+// Out is the condition that v is out of the range.
+func (r *goRange) Out(v string) string {
+	var conds []string
+	if r.Min != "" {
+		conds = append(conds, fmt.Sprintf("%v < %v", v, r.Min))
+	}
+	if r.Max != "" {
+		conds = append(conds, fmt.Sprintf("%v > %v", v, r.Max))
+	}
+	return strings.Join(conds, " || ")
+}
+
+func (t *goType) hasRange() bool {
+	return t.any(func(t *goType) bool { return t.Range() != nil })
+}
+
+func (t *goType) hasReadRange() bool {
+	return t.any(func(t *goType) bool { return t.ReadRange() != nil })
+}
+
+func (t *goType) hasTime() bool {
+	return t.any(func(t *goType) bool { return t.Kind == Timestamp || t.Kind == Duration })
+}
+
+// NeedsValidate reports whether Validate checks values of t: integers the
+// wire cannot hold and structs, which may be nil or fail their own Validate.
+func (t *goType) NeedsValidate() bool {
+	return t.any(func(t *goType) bool { return t.Range() != nil || t.IsStruct() })
+}
+
+// usesStError reports whether decoding t can fail with errors.NewStError.
+func (t *goType) usesStError() bool {
+	return t.any(func(t *goType) bool {
+		return t.IsBase() || t.IsMap() || t.IsArray() || t.IsSet() || t.Kind == Oneof
+	})
+}
+
+// any reports whether f holds for t or any type nested in it.
+func (t *goType) any(f func(t *goType) bool) bool {
+	if f(t) {
+		return true
+	}
+	for _, sub := range []*goType{t.Elem, t.Key, t.Value} {
+		if sub != nil && sub.any(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Codec starts the recursion of the "writeValue"/"readValue" templates for
+// the variable v.
+func (t *goType) Codec(v string) *goValue {
+	return &goValue{Type: t, Var: v, Depth: 1}
+}
+
+// goValue is a variable being encoded or decoded. Depth grows with every
+// nested list or map so generated loop variables never shadow each other.
+// Field names the stproto field the value belongs to in error messages, and
+// Optional marks the value of an optional field, which is only handled when
+// set.
+type goValue struct {
+	Type     *goType
+	Var      string
+	Depth    int
+	Field    string
+	Optional bool
+}
+
+// Bytes is the []byte expression of a list or array of byte.
+func (v *goValue) Bytes() string {
+	if v.Type.IsArray() {
+		return goParen(v.Var) + "[:]"
+	}
+	return v.Var
+}
+
+// goParen wraps a dereference so that a selector or index applies to the
+// pointed-to value.
+func goParen(v string) string {
+	if strings.HasPrefix(v, "*") {
+		return "(" + v + ")"
+	}
+	return v
+}
+
+// Sub returns the nested value of type t, named prefix followed by the new
+// depth, e.g. "e2" for the elements of a top-level list.
+func (v *goValue) Sub(t *goType, prefix string) *goValue {
+	return &goValue{Type: t, Var: fmt.Sprintf("%v%v", prefix, v.Depth+1), Depth: v.Depth + 1, Field: v.Field}
+}
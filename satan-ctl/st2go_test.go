@@ -0,0 +1,2224 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testStProto = `
+// Person is a user.
+struct Person {
+    // public name
+    name string // not unique
+    tags []string
+    scores map[string][]int
+    friends []Person
+}
+
+// SayHi greets.
+func SayHi {
+    req(
+        who Person
+    )
+    rsp(
+        msg string
+    )
+}
+`
+
+func writeTestStProto(t *testing.T, text string) string {
+	dir := path.Join(t.TempDir(), "demo")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	filePath := path.Join(dir, "greeter.stproto")
+	if err := ioutil.WriteFile(filePath, []byte(text), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func genTestGoFile(t *testing.T, text string, templateDir string) string {
+	return genTestGoFileOptions(t, text, templateDir, st2GoOptions{jsonCase: stJSONCaseName})
+}
+
+// genTestGoFileOptions is genTestGoFile with the st2go flags set in opts.
+func genTestGoFileOptions(t *testing.T, text string, templateDir string, opts st2GoOptions) string {
+	filePath := writeTestStProto(t, text)
+	psr, err := loadStProtoFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadGoTemplate(templateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := psr.toGoFile(tmpl, opts); err != nil {
+		t.Fatal(err)
+	}
+	buff, err := ioutil.ReadFile(filePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestGoFile(t, string(buff))
+	return string(buff)
+}
+
+// testSatanGoPackages stand in for the satanGo runtime when type checking and
+// running generated code. StBuffer records every write and replays it to the
+// reads, so a decoder that does not mirror its encoder fails.
+var testSatanGoPackages = map[string]string{
+	"satanGo/satan/errors": `package errors
+
+import "fmt"
+
+type StError struct{ Code int }
+
+func (e *StError) Error() string { return fmt.Sprintf("satan error %v", e.Code) }
+
+func NewStError(code int) error { return &StError{code} }
+`,
+	"satanGo/satan/protocol": `package protocol
+
+import (
+	"fmt"
+	"math"
+)
+
+type DataType byte
+
+const (
+	Unknown DataType = iota
+	Byte
+	Bool
+	Int
+	Long
+	Float
+	Double
+	String
+	List
+	Map
+	Struct
+)
+
+type StBuffer struct {
+	ops []stOp
+	pos int
+}
+
+type stOp struct {
+	name string
+	v    interface{}
+}
+
+func (b *StBuffer) put(name string, v interface{}) error {
+	b.ops = append(b.ops, stOp{name, v})
+	return nil
+}
+
+func (b *StBuffer) get(name string) (interface{}, error) {
+	if b.pos == len(b.ops) {
+		return nil, fmt.Errorf("read %v: nothing left", name)
+	}
+	op := b.ops[b.pos]
+	if op.name != name {
+		return nil, fmt.Errorf("read %v: next is %v", name, op.name)
+	}
+	b.pos++
+	return op.v, nil
+}
+
+// Unread returns the number of writes not read yet.
+func (b *StBuffer) Unread() int { return len(b.ops) - b.pos }
+
+func (b *StBuffer) WriteStructLength(l int) error {
+	if l < 0 || l > math.MaxUint8 {
+		return fmt.Errorf("struct length %v", l)
+	}
+	return b.put("struct length", byte(l))
+}
+
+func (b *StBuffer) ReadStructLength() (byte, error) {
+	v, err := b.get("struct length")
+	if err != nil {
+		return 0, err
+	}
+	return v.(byte), nil
+}
+
+func (b *StBuffer) WriteTag(t int) error {
+	if t < 0 || t > math.MaxUint8 {
+		return fmt.Errorf("tag %v", t)
+	}
+	return b.put("tag", byte(t))
+}
+
+func (b *StBuffer) ReadTag() (byte, error) {
+	v, err := b.get("tag")
+	if err != nil {
+		return 0, err
+	}
+	return v.(byte), nil
+}
+
+func (b *StBuffer) WriteDataType(t DataType) error { return b.put("data type", t) }
+
+func (b *StBuffer) ReadDataType() (DataType, error) {
+	v, err := b.get("data type")
+	if err != nil {
+		return 0, err
+	}
+	return v.(DataType), nil
+}
+
+func (b *StBuffer) WriteDataBuf(t DataType, v interface{}) error {
+	var ok bool
+	switch t {
+	case Byte:
+		_, ok = v.(byte)
+	case Bool:
+		_, ok = v.(bool)
+	case Int:
+		var i int
+		i, ok = v.(int)
+		ok = ok && i >= math.MinInt32 && i <= math.MaxInt32
+	case Long:
+		_, ok = v.(int64)
+	case Float:
+		_, ok = v.(float32)
+	case Double:
+		_, ok = v.(float64)
+	case String:
+		_, ok = v.(string)
+	}
+	if !ok {
+		return fmt.Errorf("cannot write %T %v as data type %v", v, v, t)
+	}
+	return b.put(fmt.Sprintf("data type %v", t), v)
+}
+
+func (b *StBuffer) ReadDataBuf(t DataType) (interface{}, error) {
+	return b.get(fmt.Sprintf("data type %v", t))
+}
+
+func (b *StBuffer) WriteLength(l int) error {
+	if l < 0 {
+		return fmt.Errorf("length %v", l)
+	}
+	return b.put("length", l)
+}
+
+func (b *StBuffer) ReadLength() (int, error) {
+	v, err := b.get("length")
+	if err != nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
+
+func (b *StBuffer) WriteBytes(v []byte) error { return b.put("bytes", append([]byte{}, v...)) }
+
+func (b *StBuffer) ReadBytes(l int) ([]byte, error) {
+	v, err := b.get("bytes")
+	if err != nil {
+		return nil, err
+	}
+	if len(v.([]byte)) != l {
+		return nil, fmt.Errorf("read %v bytes, %v were written", l, len(v.([]byte)))
+	}
+	return v.([]byte), nil
+}
+`,
+}
+
+// testGoHelpers is a test file added to the generated package by
+// runTestGoFile.
+const testGoHelpers = `package demo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type stStruct interface {
+	WriteDataBuf(bf *protocol.StBuffer) error
+	ReadDataBuf(bf *protocol.StBuffer) error
+}
+
+// roundTrip encodes in, decodes it into out and compares the two.
+func roundTrip(t *testing.T, in stStruct, out stStruct) {
+	t.Helper()
+	bf := &protocol.StBuffer{}
+	if err := in.WriteDataBuf(bf); err != nil {
+		t.Fatalf("encode %+v: %v", in, err)
+	}
+	if err := out.ReadDataBuf(bf); err != nil {
+		t.Fatalf("decode %+v: %v", in, err)
+	}
+	if n := bf.Unread(); n != 0 {
+		t.Errorf("decode %+v left %v writes unread", in, n)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("decoded %+v, want %+v", out, in)
+	}
+}
+
+// loopback is an invoker calling a dispatch func in process, with a fresh
+// buffer for the request and the response like a transport.
+type loopback func(ctx context.Context, funcName string, reqBf *protocol.StBuffer, rspBf *protocol.StBuffer) error
+
+func (l loopback) Invoke(ctx context.Context, funcName string, writeReq func(bf *protocol.StBuffer) error, readRsp func(bf *protocol.StBuffer) error) error {
+	reqBf := &protocol.StBuffer{}
+	if err := writeReq(reqBf); err != nil {
+		return err
+	}
+	rspBf := &protocol.StBuffer{}
+	if err := l(ctx, funcName, reqBf, rspBf); err != nil {
+		return err
+	}
+	if readRsp == nil {
+		return nil
+	}
+	return readRsp(rspBf)
+}
+
+// errUnavailable is the failure loopback reports as retryable.
+var errUnavailable = errors.New("service unavailable")
+
+func (l loopback) Retryable(err error) bool {
+	return errors.Is(err, errUnavailable)
+}
+`
+
+// runTestGoFile runs testSrc, a test file of the package generated as src,
+// with go test against testSatanGoPackages.
+func runTestGoFile(t *testing.T, src string, testSrc string) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("running generated code needs the go command")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":                  "module satanGo\n\ngo 1.16\n",
+		"demo/greeter.stproto.go": src,
+		"demo/helpers_test.go":    testGoHelpers,
+		"demo/greeter_test.go":    testSrc,
+	}
+	for p, pkgSrc := range testSatanGoPackages {
+		files[path.Join(strings.TrimPrefix(p, "satanGo/"), path.Base(p)+".go")] = pkgSrc
+	}
+	for name, content := range files {
+		filePath := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(filePath), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goCmd, "test", "./demo")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GOTOOLCHAIN=local")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code fails its test: %v\n%s", err, out)
+	}
+}
+
+// goDocs returns the doc comment of every declaration of src by name: "T" for
+// a type, func, const or var, "T.M" for a field, interface method or method of
+// T, and "X //" for the comment trailing the field or const X.
+func goDocs(t *testing.T, src string) map[string]string {
+	f, err := parser.ParseFile(token.NewFileSet(), "demo.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]string)
+	addFields := func(prefix string, fields *ast.FieldList) {
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				docs[prefix+name.Name] = strings.TrimSpace(field.Doc.Text())
+				if field.Comment != nil {
+					docs[prefix+name.Name+" //"] = strings.TrimSpace(field.Comment.Text())
+				}
+			}
+		}
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			name := decl.Name.Name
+			if decl.Recv != nil {
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				name = recv.(*ast.Ident).Name + "." + name
+			}
+			docs[name] = strings.TrimSpace(decl.Doc.Text())
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					doc := spec.Doc
+					if !decl.Lparen.IsValid() {
+						doc = decl.Doc
+					}
+					docs[spec.Name.Name] = strings.TrimSpace(doc.Text())
+					switch typ := spec.Type.(type) {
+					case *ast.StructType:
+						addFields(spec.Name.Name+".", typ.Fields)
+					case *ast.InterfaceType:
+						addFields(spec.Name.Name+".", typ.Methods)
+					}
+				case *ast.ValueSpec:
+					doc := spec.Doc
+					if !decl.Lparen.IsValid() {
+						doc = decl.Doc
+					}
+					for _, name := range spec.Names {
+						docs[name.Name] = strings.TrimSpace(doc.Text())
+						if spec.Comment != nil {
+							docs[name.Name+" //"] = strings.TrimSpace(spec.Comment.Text())
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+type testImporter struct {
+	fset *token.FileSet
+	std  types.Importer
+	pkgs map[string]*types.Package
+}
+
+func (imp *testImporter) Import(p string) (*types.Package, error) {
+	if pkg := imp.pkgs[p]; pkg != nil {
+		return pkg, nil
+	}
+	src, ok := testSatanGoPackages[p]
+	if !ok {
+		return imp.std.Import(p)
+	}
+	pkg, err := typeCheckGoSource(imp, p, src)
+	imp.pkgs[p] = pkg
+	return pkg, err
+}
+
+func typeCheckGoSource(imp *testImporter, p string, src string) (*types.Package, error) {
+	f, err := parser.ParseFile(imp.fset, path.Base(p)+".go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: imp}
+	return conf.Check(p, imp.fset, []*ast.File{f}, nil)
+}
+
+// testGoImporter is shared by all tests, importing the standard library from
+// source is slow.
+var testGoImporter *testImporter
+
+// checkTestGoFile type checks generated code against testSatanGoPackages.
+func checkTestGoFile(t *testing.T, src string) {
+	if testGoImporter == nil {
+		fset := token.NewFileSet()
+		testGoImporter = &testImporter{fset: fset, std: importer.ForCompiler(fset, "source", nil), pkgs: make(map[string]*types.Package)}
+	}
+	if _, err := typeCheckGoSource(testGoImporter, "demo", src); err != nil {
+		t.Errorf("generated code does not compile: %v\n%v", err, src)
+	}
+}
+
+func TestToGoFile(t *testing.T) {
+	src := genTestGoFile(t, testStProto, "")
+	docs := goDocs(t, src)
+	for name, want := range map[string]string{
+		"Person":               "Person is a user.",
+		"Person.Name":          "public name",
+		"Person.Name //":       "not unique",
+		"GreeterServant.SayHi": "SayHi greets.",
+		"GreeterClient.SayHi":  "SayHi greets.",
+	} {
+		if got := docs[name]; got != want {
+			t.Errorf("doc of %v = %q, want %q", name, got, want)
+		}
+	}
+
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type greeter struct{}
+
+func (greeter) SayHi(ctx context.Context, req *SayHiReq) (*SayHiRsp, error) {
+	rsp := NewSayHiRsp()
+	rsp.Msg = fmt.Sprintf("hi %v and %v friends", req.Who.Name, len(req.Who.Friends))
+	return rsp, nil
+}
+
+func TestPerson(t *testing.T) {
+	friend := NewPerson()
+	friend.Name = "bob"
+	p := NewPerson()
+	p.Name = "ann"
+	p.Tags = []string{"a", "b"}
+	p.Scores = map[string][]int{"x": {1, -2}, "y": {}}
+	p.Friends = []*Person{friend, NewPerson()}
+	roundTrip(t, p, NewPerson())
+	roundTrip(t, NewPerson(), NewPerson())
+
+	c := NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		return DispatchGreeter(ctx, greeter{}, funcName, reqBf, rspBf)
+	}))
+	req := NewSayHiReq()
+	req.Who = p
+	rsp, err := c.SayHi(context.Background(), req)
+	if err != nil || rsp.Msg != "hi ann and 2 friends" {
+		t.Errorf("SayHi = %+v, %v", rsp, err)
+	}
+}
+`)
+}
+
+func TestLoadGoTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	tagTmpl := `{{define "fieldTag"}}json:"{{.Name}},omitempty"{{end}}`
+	if err := ioutil.WriteFile(path.Join(dir, "tag.tmpl"), []byte(tagTmpl), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	runTestGoFile(t, genTestGoFile(t, testStProto, dir), `package demo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldTag(t *testing.T) {
+	p := NewPerson()
+	p.Name = "ann"
+	if b, err := json.Marshal(p); err != nil || string(b) != "{\"name\":\"ann\"}" {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+}
+`)
+
+	if _, err := loadGoTemplate(t.TempDir()); err == nil {
+		t.Error("expected an error for a template directory without *.tmpl files")
+	}
+}
+
+func TestGoNameCollisions(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+struct Person {
+    id int
+    Id string
+    writeDataBuf bool
+    _x int
+}
+
+struct NewPerson {
+    a int
+}
+
+struct person {
+    a int
+}
+
+func SayHi {
+    req(
+        who Person
+    )
+    rsp(
+        msg string
+    )
+}
+
+func sayHi {
+    req(
+        a int
+    )
+    rsp(
+        b int
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = psr.checkGoNames()
+	if err == nil {
+		t.Fatal("expected Go name errors")
+	}
+	for _, want := range []string{
+		"line 9: struct NewPerson: Go name NewPerson collides with constructor of struct Person (line 2)",
+		"line 13: struct person: Go name Person collides with struct Person (line 2)",
+		"line 27: struct sayHiReq: Go name SayHiReq collides with struct SayHiReq (line 18)",
+		"line 4: field Person.Id: Go name Id collides with field Person.id (line 3)",
+		"line 5: field Person.writeDataBuf: Go name WriteDataBuf collides with the generated WriteDataBuf method",
+		"line 6: field Person._x: Go name _x is not exported",
+		"line 26: func sayHi: Go name SayHi collides with func SayHi (line 17)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+
+	src := genTestGoFile(t, `
+struct person go_name(Human) {
+    id int
+    Id string go_name(IdText)
+}
+
+func sayHi go_name("Greet") {
+    req(
+        who person
+    )
+    rsp(
+        msg string
+    )
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type greeter struct{}
+
+func (greeter) Greet(ctx context.Context, req *GreetReq) (*GreetRsp, error) {
+	rsp := NewGreetRsp()
+	rsp.Msg = req.Who.IdText
+	return rsp, nil
+}
+
+func TestGoNames(t *testing.T) {
+	var funcNames []string
+	c := NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		funcNames = append(funcNames, funcName)
+		return DispatchGreeter(ctx, greeter{}, funcName, reqBf, rspBf)
+	}))
+	req := NewGreetReq()
+	req.Who = &Human{Id: 1, IdText: "one"}
+	rsp, err := c.Greet(context.Background(), req)
+	if err != nil || rsp.Msg != "one" {
+		t.Errorf("Greet = %+v, %v", rsp, err)
+	}
+	if len(funcNames) != 1 || funcNames[0] != "sayHi" {
+		t.Errorf("Greet invoked %q, want the stproto name sayHi", funcNames)
+	}
+}
+`)
+}
+
+func TestToGoFileOptional(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Node {
+    name string
+    age int?
+    next Node optional
+}
+
+func Get {
+    req(
+        id long?
+    )
+    rsp(
+        node Node
+    )
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestOptional(t *testing.T) {
+	age := 7
+	n := NewNode()
+	n.Name = "a"
+	n.Age = &age
+	n.Next = NewNode()
+	roundTrip(t, n, NewNode())
+	roundTrip(t, NewNode(), NewNode())
+
+	// unset optional fields are not written
+	bf := &protocol.StBuffer{}
+	if err := NewGetReq().WriteDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := bf.ReadStructLength(); err != nil || l != 0 || bf.Unread() != 0 {
+		t.Errorf("empty GetReq wrote struct length %v, %v and %v more", l, err, bf.Unread())
+	}
+	id := int64(1)
+	roundTrip(t, &GetReq{Id: &id}, NewGetReq())
+}
+`)
+}
+
+func TestToGoFileIntTypes(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Nums {
+    a int
+    b uint
+    c short
+    d int32
+    e map[uint64][]int
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"math"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestIntTypes(t *testing.T) {
+	n := NewNums()
+	n.A = math.MinInt32
+	n.B = math.MaxUint32
+	n.C = math.MinInt16
+	n.D = math.MaxInt32
+	n.E = map[uint64][]int{0: {}, math.MaxInt64: {math.MaxInt32}}
+	roundTrip(t, n, NewNums())
+
+	for _, c := range []struct {
+		n    *Nums
+		want string
+	}{
+		{&Nums{A: math.MaxInt32 + 1}, "a: value 2147483648 overflows int32"},
+		{&Nums{B: math.MaxUint32 + 1}, "b: value 4294967296 overflows uint32"},
+		{&Nums{E: map[uint64][]int{math.MaxInt64 + 1: nil}}, "e: value 9223372036854775808 overflows int64"},
+		{&Nums{E: map[uint64][]int{1: {math.MinInt32 - 1}}}, "e: value -2147483649 overflows int32"},
+	} {
+		if err := c.n.WriteDataBuf(&protocol.StBuffer{}); err == nil || err.Error() != c.want {
+			t.Errorf("encode %+v: got error %v, want %v", c.n, err, c.want)
+		}
+	}
+
+	// values of another implementation that do not fit the Go type
+	for _, c := range []struct {
+		tag int
+		typ protocol.DataType
+		v   interface{}
+	}{
+		{1, protocol.Long, int64(-1)},
+		{1, protocol.Long, int64(math.MaxUint32 + 1)},
+		{2, protocol.Int, math.MaxInt16 + 1},
+	} {
+		bf := &protocol.StBuffer{}
+		bf.WriteStructLength(1)
+		bf.WriteTag(c.tag)
+		bf.WriteDataType(c.typ)
+		bf.WriteDataBuf(c.typ, c.v)
+		if err := NewNums().ReadDataBuf(bf); err == nil {
+			t.Errorf("decoded %v into tag %v", c.v, c.tag)
+		}
+	}
+}
+`)
+}
+
+func TestToGoFileTimeTypes(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Event {
+    at timestamp
+    took duration?
+    data bytes
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+	"time"
+
+	"satanGo/satan/protocol"
+)
+
+func TestTimeTypes(t *testing.T) {
+	took := 1500 * time.Millisecond
+	e := NewEvent()
+	e.At = time.Date(2024, 2, 29, 12, 30, 0, 125e6, time.UTC)
+	e.Took = &took
+	e.Data = []byte{0, 1, 255}
+	roundTrip(t, e, NewEvent())
+
+	// timestamps are sent in milliseconds
+	at := time.Date(2024, 2, 29, 12, 30, 0, 125e6+456, time.UTC)
+	bf := &protocol.StBuffer{}
+	if err := (&Event{At: at}).WriteDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	got := NewEvent()
+	if err := got.ReadDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	if want := at.Truncate(time.Millisecond); !got.At.Equal(want) || got.At.Equal(at) {
+		t.Errorf("decoded %v, want %v", got.At, want)
+	}
+
+	for _, at := range []int64{-62135596800001, 253402300800000} {
+		bf := &protocol.StBuffer{}
+		bf.WriteStructLength(1)
+		bf.WriteTag(0)
+		bf.WriteDataType(protocol.Long)
+		bf.WriteDataBuf(protocol.Long, at)
+		if err := NewEvent().ReadDataBuf(bf); err == nil {
+			t.Errorf("decoded the timestamp %v outside years 1 to 9999", at)
+		}
+	}
+
+	bf = &protocol.StBuffer{}
+	bf.WriteStructLength(1)
+	bf.WriteTag(2)
+	bf.WriteDataType(protocol.List)
+	bf.WriteDataType(protocol.Int)
+	if err := NewEvent().ReadDataBuf(bf); err == nil {
+		t.Error("decoded a list of int as bytes")
+	}
+}
+`)
+
+	want := "Timestamps are sent in milliseconds, finer times are truncated."
+	if got := goDocs(t, src)["Event.At"]; got != want {
+		t.Errorf("Event.At doc = %q, want %q", got, want)
+	}
+
+	if _, err := loadStProtoFile(writeTestStProto(t, "struct A {\n    m map[bytes]int\n}\n")); err == nil {
+		t.Error("expected an error for a bytes map key")
+	}
+}
+
+func TestToGoFileNestedTypes(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Key {
+    a int
+    b string
+}
+
+struct Foo {
+    x int
+}
+
+struct All {
+    a map[string][]map[int]Foo
+    b []map[string]int
+    c map[string][]int
+    d [][]map[Key][]Foo
+    e map[Key]map[long]Foo
+    f map[int][][]byte
+    g []map[Key]bytes
+    h map[uint32]map[Key][]timestamp
+    i map[Key]Key
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNestedTypes(t *testing.T) {
+	k1, k2 := Key{A: 1, B: "one"}, Key{A: 2}
+	at := time.Unix(1700000000, 5e6).UTC()
+	a := NewAll()
+	a.A = map[string][]map[int]*Foo{"x": {{1: &Foo{X: 1}, 2: NewFoo()}, {}}, "y": {}}
+	a.B = []map[string]int{{"a": 1}, {}}
+	a.C = map[string][]int{"c": {3, 4}}
+	a.D = [][]map[Key][]*Foo{{{k1: {&Foo{X: 5}}, k2: {}}}, {}}
+	a.E = map[Key]map[int64]*Foo{k1: {-1: &Foo{X: 6}}}
+	a.F = map[int][][]byte{7: {{1, 2}, {}}}
+	a.G = []map[Key][]byte{{k2: {8}}}
+	a.H = map[uint32]map[Key][]time.Time{9: {k1: {at, at.Add(time.Hour)}}}
+	a.I = map[Key]*Key{k1: &k2}
+	roundTrip(t, a, NewAll())
+	roundTrip(t, NewAll(), NewAll())
+}
+`)
+
+	for text, want := range map[string]string{
+		"struct K {\n    a []int\n}\nstruct A {\n    m map[K]int\n}\n":  "line 5: field A.m: struct K cannot be a map key, field K.a is not a required base type",
+		"struct K {\n    a int?\n}\nstruct A {\n    m []map[K]int\n}\n": "line 5: field A.m: struct K cannot be a map key, field K.a is not a required base type",
+		"struct A {\n    m map[[]int]int\n}\n":                          "line 2: struct A parse error: field m type \"map[[]int]int\" error",
+		"struct Abc {\n    m map[Xyz]Qrs\n}\n":                          "line 2: field Abc.m: undefined type \"Xyz\"\nline 2: field Abc.m: undefined type \"Qrs\"",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("load %q error = %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestToGoFileArraysAndSets(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Key {
+    a int
+}
+
+struct Coll {
+    a [3]int
+    b [16]byte
+    c set<string>
+    d set<Key>
+    e [2][]set<long>
+    f [4]byte?
+    g map[string][2]Key
+    h set<byte>
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestArraysAndSets(t *testing.T) {
+	f := [4]byte{1, 2, 3, 4}
+	c := NewColl()
+	c.A = [3]int{1, 2, 3}
+	c.B = [16]byte{15: 1}
+	c.C = map[string]struct{}{"a": {}, "b": {}}
+	c.D = map[Key]struct{}{{A: 1}: {}}
+	c.E = [2][]map[int64]struct{}{{{1: {}}, {}}, {}}
+	c.F = &f
+	c.G = map[string][2]*Key{"g": {NewKey(), &Key{A: 2}}}
+	c.H = map[byte]struct{}{0: {}, 255: {}}
+	roundTrip(t, c, NewColl())
+	if NewColl().A != [3]int{} {
+		t.Error("NewColl sets A")
+	}
+
+	// a set with a duplicate
+	bf := &protocol.StBuffer{}
+	bf.WriteStructLength(1)
+	bf.WriteTag(2)
+	bf.WriteDataType(protocol.List)
+	bf.WriteDataType(protocol.String)
+	bf.WriteLength(2)
+	bf.WriteDataBuf(protocol.String, "a")
+	bf.WriteDataBuf(protocol.String, "a")
+	if err := NewColl().ReadDataBuf(bf); err == nil {
+		t.Error("decoded a set with a duplicate")
+	}
+
+	// an array of the wrong length
+	bf = &protocol.StBuffer{}
+	bf.WriteStructLength(1)
+	bf.WriteTag(0)
+	bf.WriteDataType(protocol.List)
+	bf.WriteDataType(protocol.Int)
+	bf.WriteLength(2)
+	if err := NewColl().ReadDataBuf(bf); err == nil {
+		t.Error("decoded 2 elements into [3]int")
+	}
+}
+`)
+
+	for text, want := range map[string]string{
+		"struct A {\n    a [0]int\n}\n":                             "line 2: struct A parse error: field a type \"[0]int\" error",
+		"struct A {\n    a set<[]int>\n}\n":                         "line 2: struct A parse error: field a type \"set<[]int>\" error",
+		"struct A {\n    a [2]A\n}\n":                               "line 1: struct A contains itself: A.a -> A, use a list, map or optional field to break the cycle",
+		"struct K {\n    a bytes\n}\nstruct A {\n    a set<K>\n}\n": "line 5: field A.a: struct K cannot be a set element, field K.a is not a required base type",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("load %q error = %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestToGoFileOneof(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Foo {
+    x int
+}
+
+struct Result {
+    id long
+    // what happened
+    oneof outcome {
+        // the value
+        ok Foo
+        err string
+        codes []int
+    }
+}
+`, "")
+	if got := goDocs(t, src)["Result.Outcome"]; got != "what happened" {
+		t.Errorf("doc of the oneof field = %q", got)
+	}
+	if got := goDocs(t, src)["ResultOutcomeOk"]; got != "the value" {
+		t.Errorf("doc of the variant = %q", got)
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type otherOutcome struct{}
+
+func (*otherOutcome) isResultOutcome() {}
+
+func TestOneof(t *testing.T) {
+	for _, o := range []ResultOutcome{
+		nil,
+		&ResultOutcomeOk{Ok: &Foo{X: 1}},
+		&ResultOutcomeErr{Err: "failed"},
+		&ResultOutcomeCodes{Codes: []int{1, 2}},
+	} {
+		r := NewResult()
+		r.Id = 1
+		r.Outcome = o
+		roundTrip(t, r, NewResult())
+	}
+
+	for _, c := range []struct {
+		o    ResultOutcome
+		want string
+	}{
+		{(*ResultOutcomeOk)(nil), "outcome: variant ok is a nil *ResultOutcomeOk"},
+		{&otherOutcome{}, "outcome: unknown variant *demo.otherOutcome"},
+	} {
+		r := NewResult()
+		r.Outcome = c.o
+		if err := r.WriteDataBuf(&protocol.StBuffer{}); err == nil || err.Error() != c.want {
+			t.Errorf("encode %T: got error %v, want %v", c.o, err, c.want)
+		}
+		if err := r.Validate(); err == nil || err.Error() != c.want {
+			t.Errorf("validate %T: got error %v, want %v", c.o, err, c.want)
+		}
+	}
+
+	// the oneof twice
+	bf := &protocol.StBuffer{}
+	bf.WriteStructLength(2)
+	for i := 0; i < 2; i++ {
+		bf.WriteTag(1)
+		bf.WriteDataType(protocol.Struct)
+		bf.WriteStructLength(1)
+		bf.WriteTag(1)
+		bf.WriteDataType(protocol.String)
+		bf.WriteDataBuf(protocol.String, "x")
+	}
+	if err := NewResult().ReadDataBuf(bf); err == nil {
+		t.Error("decoded two values of a oneof")
+	}
+}
+`)
+
+	for text, want := range map[string]string{
+		"struct A {\n    oneof o {\n    }\n}\n":                                                  "line 2: oneof A.o is empty, it must have at least one variant",
+		"struct A {\n    oneof o {\n        a int?\n    }\n}\n":                                  "line 3: oneof A.o parse error: variant a cannot be optional",
+		"struct A {\n    oneof o {\n        oneof p {\n            a int\n        }\n    }\n}\n": "line 3: oneof A.o parse error: oneof cannot be nested",
+		"struct A {\n    oneof o {\n        b Xyz\n    }\n}\n":                                   "line 2: field A.o: undefined type \"Xyz\"",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("load %q error = %v, want %v", text, err, want)
+		}
+	}
+}
+
+func TestGoNameOneofCollisions(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+struct Result {
+    oneof outcome {
+        ok int
+        Ok string
+    }
+}
+
+struct ResultOutcomeOk {
+    a int
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = psr.checkGoNames()
+	if err == nil {
+		t.Fatal("expected Go name errors")
+	}
+	for _, want := range []string{
+		"line 5: variant Result.outcome.Ok: Go name Ok collides with variant ok (line 4)",
+		"line 4: variant Result.outcome.ok: Go name ResultOutcomeOk collides with struct ResultOutcomeOk (line 9)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestToGoFileEmbed(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Header {
+    traceId string
+    oneof locale {
+        code string
+    }
+}
+
+struct Paging {
+    embed Header tag(10)
+    page int
+}
+
+func List {
+    req(
+        filter string
+        embed Paging tag(20)
+    )
+    rsp(
+        items []string
+    )
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"sort"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestEmbed(t *testing.T) {
+	req := NewListReq()
+	req.Filter = "f"
+	req.Page = 2
+	req.TraceId = "t"
+	req.Locale = &HeaderLocaleCode{Code: "en"}
+	roundTrip(t, req, NewListReq())
+
+	// embedded fields start at the tag of their embed
+	bf := &protocol.StBuffer{}
+	if err := req.WriteDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := bf.ReadStructLength()
+	var tags []byte
+	for i := byte(0); i < l; i++ {
+		tg, _ := bf.ReadTag()
+		tags = append(tags, tg)
+		dt, _ := bf.ReadDataType()
+		if dt == protocol.Struct {
+			// the locale oneof holding a code
+			bf.ReadStructLength()
+			bf.ReadTag()
+			dt, _ = bf.ReadDataType()
+		}
+		if _, err := bf.ReadDataBuf(dt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	if string(tags) != string([]byte{0, 20, 30, 31}) || bf.Unread() != 0 {
+		t.Errorf("ListReq tags = %v", tags)
+	}
+}
+`)
+
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+struct Header {
+    id int
+}
+
+struct Child {
+    embed Header tag(10)
+    Id string go_name(Header)
+    Id2 int go_name(Id)
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = psr.checkGoNames()
+	if err == nil {
+		t.Fatal("expected Go name errors")
+	}
+	for _, want := range []string{
+		"line 8: field Child.Id: Go name Header collides with embedded struct Header",
+		"line 9: field Child.Id2: Go name Id collides with field Child.id (line 3)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestToGoFileConsts(t *testing.T) {
+	src := genTestGoFile(t, `
+// MaxItems bounds lists.
+const MaxItems int = 100
+const maxName long = 64 go_name(MaxName)
+const Ratio float = 1.5 // default ratio
+
+struct Page {
+    items []string max_len(MaxItems)
+    name string? max_len(maxName)
+    size short default(20)
+    ratio float default(Ratio)
+}
+`, "")
+	docs := goDocs(t, src)
+	if docs["MaxItems"] != "MaxItems bounds lists." || docs["Ratio //"] != "default ratio" {
+		t.Errorf("const docs = %q, %q", docs["MaxItems"], docs["Ratio //"])
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"strings"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestConsts(t *testing.T) {
+	var (
+		_ int     = MaxItems
+		_ int64   = MaxName
+		_ float32 = Ratio
+	)
+	if p := NewPage(); p.Size != 20 || p.Ratio != 1.5 {
+		t.Errorf("NewPage() = %+v", p)
+	}
+
+	name := strings.Repeat("n", 64)
+	p := NewPage()
+	p.Items = make([]string, 100)
+	p.Name = &name
+	roundTrip(t, p, NewPage())
+
+	long := name + "n"
+	for _, c := range []struct {
+		p    *Page
+		want string
+	}{
+		{&Page{Items: make([]string, 101)}, "items: length 101 exceeds max_len 100"},
+		{&Page{Name: &long}, "name: length 65 exceeds max_len 64"},
+	} {
+		if err := c.p.WriteDataBuf(&protocol.StBuffer{}); err == nil || err.Error() != c.want {
+			t.Errorf("encode %+v: got error %v, want %v", c.p, err, c.want)
+		}
+		if err := c.p.Validate(); err == nil || err.Error() != c.want {
+			t.Errorf("validate %+v: got error %v, want %v", c.p, err, c.want)
+		}
+	}
+
+	bf := &protocol.StBuffer{}
+	bf.WriteStructLength(1)
+	bf.WriteTag(0)
+	bf.WriteDataType(protocol.List)
+	bf.WriteDataType(protocol.String)
+	bf.WriteLength(101)
+	for i := 0; i < 101; i++ {
+		bf.WriteDataBuf(protocol.String, "")
+	}
+	if err := NewPage().ReadDataBuf(bf); err == nil {
+		t.Error("decoded 101 items")
+	}
+}
+`)
+
+	psr, err := loadStProtoFile(writeTestStProto(t, "const Page int = 1\n\nstruct Page {\n    a int\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := psr.checkGoNames(); err == nil || !strings.Contains(err.Error(), "line 3: struct Page: Go name Page collides with const Page (line 1)") {
+		t.Errorf("expected a collision with the const, got %v", err)
+	}
+}
+
+func TestToGoFileAliases(t *testing.T) {
+	src := genTestGoFile(t, `
+// UserID names a user.
+type UserID long
+type Tags []string
+type When timestamp
+type Name string go_name(FullName)
+
+struct Person {
+    id UserID
+    tags Tags
+    born When?
+    name Name default("anon")
+    friends map[UserID]Name
+}
+`, "")
+	if got := goDocs(t, src)["UserID"]; got != "UserID names a user." {
+		t.Errorf("doc of UserID = %q", got)
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAliases(t *testing.T) {
+	var (
+		_ int64     = int64(UserID(0))
+		_ []string  = Tags(nil)
+		_ time.Time = time.Time(When{})
+		_ string    = string(FullName(""))
+	)
+	if p := NewPerson(); p.Name != "anon" {
+		t.Errorf("NewPerson().Name = %q", p.Name)
+	}
+
+	born := When(time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC))
+	p := NewPerson()
+	p.Id = 1
+	p.Tags = Tags{"a"}
+	p.Born = &born
+	p.Friends = map[UserID]FullName{2: "bob"}
+	roundTrip(t, p, NewPerson())
+
+	got, err := json.Marshal(born)
+	want, _ := json.Marshal(time.Time(born))
+	if err != nil || string(got) != string(want) {
+		t.Errorf("Marshal(When) = %s, %v, want %s", got, err, want)
+	}
+}
+`)
+}
+
+func TestToGoFileDeprecated(t *testing.T) {
+	src := genTestGoFile(t, `
+// Old is the user before v2.
+struct Old deprecated("use User") {
+    name string
+}
+
+struct User {
+    // nick is shown in lists.
+    nick string deprecated
+    old Old? deprecated("drop with Old")
+}
+
+func GetUser deprecated("use LoadUser") {
+    req(
+        id long
+    )
+    rsp(
+        user User
+    )
+}
+
+func Watch deprecated {
+    req(
+        id long
+    )
+    stream rsp(
+        user User
+    )
+}
+`, "")
+	docs := goDocs(t, src)
+	for name, want := range map[string]string{
+		"Old":                    "Old is the user before v2.\n\nDeprecated: use User",
+		"User.Nick":              "nick is shown in lists.\n\nDeprecated: no longer supported",
+		"User.Old":               "Deprecated: drop with Old",
+		"GreeterServant.GetUser": "Deprecated: use LoadUser",
+	} {
+		if got := docs[name]; got != want {
+			t.Errorf("doc of %v = %q, want %q", name, got, want)
+		}
+	}
+
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type greeter struct{}
+
+func (greeter) GetUser(ctx context.Context, req *GetUserReq) (*GetUserRsp, error) {
+	return NewGetUserRsp(), nil
+}
+
+func (greeter) Watch(ctx context.Context, req *WatchReq, send func(rsp *WatchRsp) error) error {
+	return nil
+}
+
+// watchStream receives one request and drops the responses.
+type watchStream struct{}
+
+func (watchStream) Send(write func(bf *protocol.StBuffer) error) error { return nil }
+
+func (watchStream) Recv(read func(bf *protocol.StBuffer) error) error {
+	bf := &protocol.StBuffer{}
+	if err := NewWatchReq().WriteDataBuf(bf); err != nil {
+		return err
+	}
+	return read(bf)
+}
+
+func (watchStream) CloseSend() error { return nil }
+
+type invoker struct {
+	loopback
+}
+
+func (invoker) OpenStream(ctx context.Context, funcName string) (GreeterStream, error) {
+	return watchStream{}, nil
+}
+
+type observedGreeter struct {
+	greeter
+	calls []string
+}
+
+func (g *observedGreeter) DeprecatedCalled(ctx context.Context, funcName string, message string) {
+	g.calls = append(g.calls, funcName+": "+message)
+}
+
+func TestDeprecated(t *testing.T) {
+	var svt GreeterServant = greeter{}
+	c := NewGreeterClient(invoker{func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		return DispatchGreeter(ctx, svt, funcName, reqBf, rspBf)
+	}})
+	if _, err := c.GetUser(context.Background(), NewGetUserReq()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &observedGreeter{}
+	svt = g
+	if _, err := c.GetUser(context.Background(), NewGetUserReq()); err != nil {
+		t.Fatal(err)
+	}
+	if err := DispatchGreeterStream(context.Background(), g, "Watch", watchStream{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.calls) != 2 || g.calls[0] != "GetUser: use LoadUser" || g.calls[1] != "Watch: no longer supported" {
+		t.Errorf("DeprecatedCalled calls = %q", g.calls)
+	}
+}
+`)
+}
+
+func TestToGoFileTags(t *testing.T) {
+	src := genTestGoFile(t, `
+struct Person {
+    reserved 1; reserved "nick"
+    id long
+    name string tag(2)
+    age int
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+func TestTags(t *testing.T) {
+	p := NewPerson()
+	p.Id = 1
+	p.Name = "ann"
+	p.Age = 3
+	roundTrip(t, p, NewPerson())
+
+	bf := &protocol.StBuffer{}
+	if err := p.WriteDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := bf.ReadStructLength()
+	var tags []byte
+	for i := byte(0); i < l; i++ {
+		tg, _ := bf.ReadTag()
+		tags = append(tags, tg)
+		dt, _ := bf.ReadDataType()
+		bf.ReadDataBuf(dt)
+	}
+	// tag 1 is reserved
+	if string(tags) != string([]byte{0, 2, 3}) {
+		t.Errorf("Person tags = %v", tags)
+	}
+
+	// the values of the reserved tag 1 and the unknown tag 9 are skipped
+	bf = &protocol.StBuffer{}
+	bf.WriteStructLength(4)
+	bf.WriteTag(1)
+	bf.WriteDataType(protocol.Struct)
+	bf.WriteStructLength(2)
+	bf.WriteTag(0)
+	bf.WriteDataType(protocol.Map)
+	bf.WriteDataType(protocol.String)
+	bf.WriteDataType(protocol.List)
+	bf.WriteLength(1)
+	bf.WriteDataBuf(protocol.String, "a")
+	bf.WriteDataType(protocol.Int)
+	bf.WriteLength(2)
+	bf.WriteDataBuf(protocol.Int, 1)
+	bf.WriteDataBuf(protocol.Int, 2)
+	bf.WriteTag(1)
+	bf.WriteDataType(protocol.String)
+	bf.WriteDataBuf(protocol.String, "old")
+	bf.WriteTag(2)
+	bf.WriteDataType(protocol.String)
+	bf.WriteDataBuf(protocol.String, "bob")
+	bf.WriteTag(9)
+	bf.WriteDataType(protocol.List)
+	bf.WriteDataType(protocol.Byte)
+	bf.WriteLength(2)
+	bf.WriteBytes([]byte{1, 2})
+	bf.WriteTag(3)
+	bf.WriteDataType(protocol.Int)
+	bf.WriteDataBuf(protocol.Int, 4)
+	got := NewPerson()
+	if err := got.ReadDataBuf(bf); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "bob" || got.Age != 4 || bf.Unread() != 0 {
+		t.Errorf("decoded %+v, %v writes unread", got, bf.Unread())
+	}
+}
+`)
+}
+
+func TestToGoFileFuncOptions(t *testing.T) {
+	src := genTestGoFile(t, `
+func Get timeout(500ms) idempotent retry(3) {
+    req(
+        id long
+    )
+    rsp(
+        ok bool
+    )
+}
+
+func Notify oneway {
+    req(
+        event string
+    )
+}
+`, "")
+	if _, ok := goDocs(t, src)["NotifyRsp"]; ok {
+		t.Errorf("generated code declares a response for a oneway func:\n%v", src)
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"satanGo/satan/protocol"
+)
+
+type greeter struct {
+	events []string
+}
+
+func (g *greeter) Get(ctx context.Context, req *GetReq) (*GetRsp, error) {
+	deadline, ok := ctx.Deadline()
+	rsp := NewGetRsp()
+	rsp.Ok = ok && time.Until(deadline) <= 500*time.Millisecond
+	return rsp, nil
+}
+
+func (g *greeter) Notify(ctx context.Context, req *NotifyReq) error {
+	g.events = append(g.events, req.Event)
+	return nil
+}
+
+func TestFuncOptions(t *testing.T) {
+	g := &greeter{}
+	var fail error
+	failures, attempts := 0, 0
+	c := NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		attempts++
+		if attempts <= failures {
+			// a nil fail answers with an empty buffer, which does not decode
+			return fail
+		}
+		return DispatchGreeter(ctx, g, funcName, reqBf, rspBf)
+	}))
+	if c.RetryBackoff != 50*time.Millisecond {
+		t.Errorf("RetryBackoff = %v", c.RetryBackoff)
+	}
+	c.RetryBackoff = 10 * time.Millisecond
+	ctx := context.Background()
+
+	// retry(3) makes up to 4 attempts within the timeout of 500ms
+	for _, tc := range []struct {
+		fail               error
+		failures, attempts int
+		wait               time.Duration
+	}{
+		{errUnavailable, 0, 1, 0},
+		{errUnavailable, 3, 4, 70 * time.Millisecond},
+		{errUnavailable, 5, 4, 70 * time.Millisecond},
+		{errors.New("bad request"), 5, 1, 0},
+		{nil, 5, 1, 0},
+	} {
+		fail, failures, attempts = tc.fail, tc.failures, 0
+		start := time.Now()
+		rsp, err := c.Get(ctx, NewGetReq())
+		if attempts != tc.attempts {
+			t.Errorf("%v failures with %v: %v attempts, want %v", tc.failures, tc.fail, attempts, tc.attempts)
+		}
+		if ok := tc.failures < tc.attempts; ok != (err == nil) || ok && !rsp.Ok {
+			t.Errorf("%v failures with %v: Get = %+v, %v", tc.failures, tc.fail, rsp, err)
+		}
+		if took := time.Since(start); took < tc.wait {
+			t.Errorf("%v failures with %v: retried within %v, want a backoff of %v", tc.failures, tc.fail, took, tc.wait)
+		}
+	}
+	failures = 0
+
+	if err := c.Notify(ctx, &NotifyReq{Event: "e"}); err != nil || len(g.events) != 1 || g.events[0] != "e" {
+		t.Errorf("Notify = %v, events %q", err, g.events)
+	}
+}
+`)
+}
+
+func TestToGoFileErrors(t *testing.T) {
+	src := genTestGoFile(t, `
+// NotFound is returned for unknown ids.
+error NotFound = 4004 "user not found"
+
+func Get idempotent retry(1) {
+    req(
+        id long
+    )
+    rsp(
+        ok bool
+    )
+}
+`, "")
+	if got := goDocs(t, src)["ErrNotFound"]; got != "NotFound is returned for unknown ids." {
+		t.Errorf("doc of ErrNotFound = %q", got)
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	satanerrors "satanGo/satan/errors"
+	"satanGo/satan/protocol"
+)
+
+type greeter struct{}
+
+func (greeter) Get(ctx context.Context, req *GetReq) (*GetRsp, error) {
+	return nil, fmt.Errorf("get %v: %w", req.Id, ErrNotFound)
+}
+
+func TestErrors(t *testing.T) {
+	if GreeterErrorOf(4004) != ErrNotFound || GreeterErrorOf(4005) != nil {
+		t.Error("GreeterErrorOf does not look up the declared errors")
+	}
+
+	attempts := 0
+	c := NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		attempts++
+		return DispatchGreeter(ctx, greeter{}, funcName, reqBf, rspBf)
+	}))
+	_, err := c.Get(context.Background(), NewGetReq())
+	if !errors.Is(err, ErrNotFound) || err.Error() != "4004 user not found" {
+		t.Errorf("Get error = %v, want ErrNotFound", err)
+	}
+	// a declared error is the answer of the service, not a failed call
+	if attempts != 1 {
+		t.Errorf("Get made %v attempts", attempts)
+	}
+
+	c = NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		return fmt.Errorf("call failed: %w", satanerrors.NewStError(4004))
+	}))
+	if _, err := c.Get(context.Background(), NewGetReq()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get error = %v, want ErrNotFound from a wrapped *errors.StError", err)
+	}
+}
+`)
+}
+
+func TestToGoFileStreams(t *testing.T) {
+	src := genTestGoFile(t, `
+error Gone = 4010 "gone"
+
+func Chat {
+    stream req(
+        text string
+    )
+    stream rsp(
+        text string
+    )
+}
+
+func Upload {
+    stream req(
+        chunk bytes
+    )
+    rsp(
+        size long
+    )
+}
+
+func Export {
+    req(
+        filter string
+    )
+    stream rsp(
+        name string
+    )
+}
+`, "")
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+// frames is one direction of a stream, closed by CloseSend or, with err set,
+// by a failed dispatch.
+type frames struct {
+	c   chan *protocol.StBuffer
+	err error
+}
+
+type pipeStream struct {
+	in, out *frames
+}
+
+func (s pipeStream) Send(write func(bf *protocol.StBuffer) error) error {
+	bf := &protocol.StBuffer{}
+	if err := write(bf); err != nil {
+		return err
+	}
+	s.out.c <- bf
+	return nil
+}
+
+func (s pipeStream) Recv(read func(bf *protocol.StBuffer) error) error {
+	bf, ok := <-s.in.c
+	if !ok {
+		if s.in.err != nil {
+			return s.in.err
+		}
+		return io.EOF
+	}
+	return read(bf)
+}
+
+func (s pipeStream) CloseSend() error {
+	close(s.out.c)
+	return nil
+}
+
+type streamInvoker struct {
+	loopback
+	svt GreeterServant
+}
+
+func (inv streamInvoker) OpenStream(ctx context.Context, funcName string) (GreeterStream, error) {
+	req := &frames{c: make(chan *protocol.StBuffer, 16)}
+	rsp := &frames{c: make(chan *protocol.StBuffer, 16)}
+	go func() {
+		if err := DispatchGreeterStream(ctx, inv.svt, funcName, pipeStream{in: req, out: rsp}); err != nil {
+			rsp.err = err
+			close(rsp.c)
+		}
+	}()
+	return pipeStream{in: rsp, out: req}, nil
+}
+
+type greeter struct{}
+
+func (greeter) Chat(ctx context.Context, recv func() (*ChatReq, error), send func(rsp *ChatRsp) error) error {
+	for {
+		req, err := recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := send(&ChatRsp{Text: strings.ToUpper(req.Text)}); err != nil {
+			return err
+		}
+	}
+}
+
+func (greeter) Upload(ctx context.Context, recv func() (*UploadReq, error)) (*UploadRsp, error) {
+	rsp := NewUploadRsp()
+	for {
+		req, err := recv()
+		if err == io.EOF {
+			return rsp, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rsp.Size += int64(len(req.Chunk))
+	}
+}
+
+func (greeter) Export(ctx context.Context, req *ExportReq, send func(rsp *ExportRsp) error) error {
+	if req.Filter == "gone" {
+		return ErrGone
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := send(&ExportRsp{Name: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestStreams(t *testing.T) {
+	ctx := context.Background()
+	c := NewGreeterClient(streamInvoker{svt: greeter{}})
+
+	chat, err := c.Chat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.Send(&ChatReq{Text: "hi"})
+	chat.Send(&ChatReq{Text: "bye"})
+	chat.CloseSend()
+	var texts []string
+	for {
+		rsp, err := chat.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		texts = append(texts, rsp.Text)
+	}
+	if strings.Join(texts, " ") != "HI BYE" {
+		t.Errorf("Chat answered %q", texts)
+	}
+
+	upload, err := c.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Send(&UploadReq{Chunk: []byte{1, 2}})
+	upload.Send(&UploadReq{Chunk: []byte{3}})
+	if rsp, err := upload.CloseAndRecv(); err != nil || rsp.Size != 3 {
+		t.Errorf("Upload = %+v, %v", rsp, err)
+	}
+
+	export, err := c.Export(ctx, &ExportReq{Filter: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		rsp, err := export.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		names = append(names, rsp.Name)
+	}
+	if strings.Join(names, " ") != "a b" {
+		t.Errorf("Export sent %q", names)
+	}
+
+	export, err = c.Export(ctx, &ExportReq{Filter: "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := export.Next(); !errors.Is(err, ErrGone) {
+		t.Errorf("Export error = %v, want ErrGone", err)
+	}
+
+	if err := DispatchGreeter(ctx, greeter{}, "Chat", &protocol.StBuffer{}, &protocol.StBuffer{}); err == nil {
+		t.Error("DispatchGreeter handles the stream func Chat")
+	}
+}
+`)
+}
+
+func TestToGoFileServants(t *testing.T) {
+	src := genTestGoFile(t, `
+error Gone = 4010 "gone"
+
+func Ping {
+    req(
+        id int
+    )
+    rsp(
+        id int
+    )
+}
+
+// Account handles sign in.
+servant Account {
+    func Login idempotent retry(1) {
+        req(
+            name string
+        )
+        rsp(
+            ok bool
+        )
+    }
+}
+
+servant Admin {
+    func Ban oneway {
+        req(
+            id long
+        )
+    }
+}
+`, "")
+	if got, want := goDocs(t, src)["AccountServant"], "AccountServant is implemented by the Account service.\n\nAccount handles sign in."; got != want {
+		t.Errorf("doc of AccountServant = %q, want %q", got, want)
+	}
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type service struct {
+	banned []int64
+}
+
+func (s *service) Ping(ctx context.Context, req *PingReq) (*PingRsp, error) {
+	return &PingRsp{Id: req.Id}, nil
+}
+
+func (s *service) Login(ctx context.Context, req *LoginReq) (*LoginRsp, error) {
+	return nil, ErrGone
+}
+
+func (s *service) Ban(ctx context.Context, req *BanReq) error {
+	s.banned = append(s.banned, req.Id)
+	return nil
+}
+
+func TestServants(t *testing.T) {
+	s := &service{}
+	ctx := context.Background()
+
+	greeter := NewGreeterClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		return DispatchGreeter(ctx, s, funcName, reqBf, rspBf)
+	}))
+	if rsp, err := greeter.Ping(ctx, &PingReq{Id: 7}); err != nil || rsp.Id != 7 {
+		t.Errorf("Ping = %+v, %v", rsp, err)
+	}
+
+	attempts := 0
+	account := NewAccountClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		attempts++
+		return DispatchAccount(ctx, s, funcName, reqBf, rspBf)
+	}))
+	// the errors of the file are shared by all its servants
+	if _, err := account.Login(ctx, NewLoginReq()); !errors.Is(err, ErrGone) || attempts != 1 {
+		t.Errorf("Login = %v after %v attempts, want ErrGone after 1", err, attempts)
+	}
+
+	admin := NewAdminClient(loopback(func(ctx context.Context, funcName string, reqBf, rspBf *protocol.StBuffer) error {
+		return DispatchAdmin(ctx, s, funcName, reqBf, rspBf)
+	}))
+	if err := admin.Ban(ctx, &BanReq{Id: 3}); err != nil || len(s.banned) != 1 || s.banned[0] != 3 {
+		t.Errorf("Ban = %v, banned %v", err, s.banned)
+	}
+	if err := DispatchAdmin(ctx, s, "Ping", &protocol.StBuffer{}, &protocol.StBuffer{}); err == nil {
+		t.Error("DispatchAdmin handles Ping of Greeter")
+	}
+}
+`)
+}
+
+func TestToGoFileJSON(t *testing.T) {
+	const text = `
+struct Base {
+    userID long
+    oneof pick {
+        code int
+        name string json("label")
+    }
+}
+
+struct Item {
+    embed Base tag(10)
+    nickName string omitempty
+    password string json("-")
+}
+
+func Get {
+    req(
+        id long
+    )
+    rsp(
+        item Item
+    )
+}
+`
+	runTestGoFile(t, genTestGoFile(t, text, ""), `package demo
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	item := NewItem()
+	item.UserID = 1
+	item.Pick = &BasePickName{Name: "n"}
+	item.Password = "secret"
+	b, err := json.Marshal(item)
+	if want := "{\"userID\":1,\"pick\":{\"label\":\"n\"}}"; err != nil || string(b) != want {
+		t.Errorf("Marshal = %s, %v, want %s", b, err, want)
+	}
+
+	// Item decodes the fields promoted from Base itself, instead of the
+	// method promoted from Base
+	got := NewItem()
+	if err := json.Unmarshal([]byte("{\"userID\":2,\"pick\":{\"code\":3},\"nickName\":\"x\",\"password\":\"p\"}"), got); err != nil {
+		t.Fatal(err)
+	}
+	want := NewItem()
+	want.UserID = 2
+	want.Pick = &BasePickCode{Code: 3}
+	want.NickName = "x"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, want)
+	}
+
+	for _, text := range []string{
+		"{\"pick\":{\"code\":3,\"label\":\"n\"}}",
+		"{\"pick\":{\"name\":\"n\"}}",
+	} {
+		if err := json.Unmarshal([]byte(text), NewItem()); err == nil {
+			t.Errorf("Unmarshal(%v) accepts the oneof", text)
+		}
+	}
+}
+`)
+
+	psr, err := loadStProtoFile(writeTestStProto(t, text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := psr.toGoFileData(st2GoOptions{jsonCase: stJSONCaseSnake})
+	if got := fd.Structs[0].Fields[0].JSONTag(); got != "user_id" {
+		t.Errorf("snake case JSON tag = %v", got)
+	}
+
+	psr, err = loadStProtoFile(writeTestStProto(t, "struct S {\n    userId int\n    user_id int\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "struct S: userId and user_id have the same JSON name \"user_id\", rename one of them with json(...)"
+	if err := psr.toGoFileData(st2GoOptions{jsonCase: stJSONCaseSnake}).checkJSONNames(); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestToGoFileHTTP(t *testing.T) {
+	const text = `
+error NotFound = 4004 "user not found" http(404)
+error Banned = 4003 "user is banned"
+
+struct Profile {
+    age int default(7)
+    tags []string max_len(2)
+}
+
+func Get timeout(1s) {
+    req(
+        id long
+        profile Profile
+    )
+    rsp(
+        name string
+        age int
+    )
+}
+
+func Notify oneway {
+    req(
+        id long
+    )
+}
+
+servant Admin {
+    func Ban deprecated {
+        req(
+            id long
+        )
+        rsp(
+            ok bool
+        )
+    }
+}
+`
+	src := genTestGoFileOptions(t, text, "", st2GoOptions{jsonCase: stJSONCaseName, http: true})
+	runTestGoFile(t, src, `package demo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"satanGo/satan/protocol"
+)
+
+type service struct {
+	notified []int64
+	calls    []string
+}
+
+func (s *service) DeprecatedCalled(ctx context.Context, funcName string, message string) {
+	s.calls = append(s.calls, funcName+": "+message)
+}
+
+func (s *service) Get(ctx context.Context, req *GetReq) (*GetRsp, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("no timeout")
+	}
+	switch req.Id {
+	case 1:
+		return &GetRsp{Name: "ann", Age: req.Profile.Age}, nil
+	case 2:
+		return nil, ErrNotFound
+	case 3:
+		return nil, ErrBanned
+	}
+	return nil, errors.New("db down")
+}
+
+func (s *service) Notify(ctx context.Context, req *NotifyReq) error {
+	s.notified = append(s.notified, req.Id)
+	return nil
+}
+
+func (s *service) Ban(ctx context.Context, req *BanReq) (*BanRsp, error) {
+	return &BanRsp{Ok: true}, nil
+}
+
+func TestHTTP(t *testing.T) {
+	s := &service{}
+	small := NewGreeterHTTPHandler(s)
+	small.MaxBodyBytes = 8
+	for _, c := range []struct {
+		handler      http.Handler
+		method, path string
+		body         string
+		status       int
+		rsp          string
+	}{
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":1}", 200, "{\"name\":\"ann\",\"age\":7}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":1,\"profile\":{\"tags\":[]}}", 200, "{\"name\":\"ann\",\"age\":7}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":2}", 404, "{\"code\":4004,\"message\":\"user not found\"}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":3}", 400, "{\"code\":4003,\"message\":\"user is banned\"}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":4}", 500, "{\"code\":0,\"message\":\"Internal Server Error\"}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":", 400, ""},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":1,\"profile\":null}", 400, "{\"code\":0,\"message\":\"profile: missing Profile\"}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":1,\"profile\":{\"tags\":[\"a\",\"b\",\"c\"]}}", 400, "{\"code\":0,\"message\":\"profile: tags: length 3 exceeds max_len 2\"}"},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Get", "{\"id\":1,\"profile\":{\"age\":3000000000}}", 400, "{\"code\":0,\"message\":\"profile: age: value 3000000000 overflows int32\"}"},
+		{small, "POST", "/greeter/Get", "{\"id\":1}", 200, ""},
+		{small, "POST", "/greeter/Get", "{\"id\":10}", 413, "{\"code\":0,\"message\":\"Request Entity Too Large\"}"},
+		{NewGreeterHTTPHandler(s), "GET", "/greeter/Get", "", 405, ""},
+		{NewGreeterHTTPHandler(s), "POST", "/Greeter/Get", "{}", 404, ""},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Ban", "{}", 404, ""},
+		{NewGreeterHTTPHandler(s), "POST", "/greeter/Notify", "{\"id\":5}", 204, ""},
+		{NewAdminHTTPHandler(s), "POST", "/Admin/Ban", "{\"id\":6}", 200, "{\"ok\":true}"},
+	} {
+		w := httptest.NewRecorder()
+		c.handler.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if w.Code != c.status || c.rsp != "" && strings.TrimSpace(w.Body.String()) != c.rsp {
+			t.Errorf("%v %v %v = %v %v, want %v %v", c.method, c.path, c.body, w.Code, w.Body, c.status, c.rsp)
+		}
+	}
+	if len(s.notified) != 1 || s.notified[0] != 5 {
+		t.Errorf("notified %v", s.notified)
+	}
+	if len(s.calls) != 1 || s.calls[0] != "Ban: no longer supported" {
+		t.Errorf("DeprecatedCalled calls = %q", s.calls)
+	}
+
+	// a profile missing on the wire keeps its defaults as well
+	reqBf, rspBf := &protocol.StBuffer{}, &protocol.StBuffer{}
+	reqBf.WriteStructLength(1)
+	reqBf.WriteTag(0)
+	reqBf.WriteDataType(protocol.Long)
+	reqBf.WriteDataBuf(protocol.Long, int64(1))
+	if err := DispatchGreeter(context.Background(), s, "Get", reqBf, rspBf); err != nil {
+		t.Fatal(err)
+	}
+	rsp := NewGetRsp()
+	if err := rsp.ReadDataBuf(rspBf); err != nil || rsp.Age != 7 {
+		t.Errorf("Dispatch Get = %+v, %v, want age 7", rsp, err)
+	}
+}
+`)
+
+	if _, ok := goDocs(t, genTestGoFile(t, text, ""))["GreeterHTTPHandler"]; ok {
+		t.Error("HTTP handler generated without the http option")
+	}
+}
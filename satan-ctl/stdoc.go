@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// docTemplateFS holds the stdoc templates: markdown.tmpl is executed with
+// text/template and html.tmpl with html/template.
+//
+//go:embed template/doc/*.tmpl
+var docTemplateFS embed.FS
+
+var StDoc = &StDocCommand{}
+
+type StDocCommand struct {
+	directory string
+	output    string
+	format    string
+}
+
+func (c *StDocCommand) ParseArgs(args []string) error {
+	fs := flag.NewFlagSet("stdoc", flag.ContinueOnError)
+	directory := fs.String("d", "./", "stproto file directory")
+	output := fs.String("o", "./stdoc", "output directory")
+	format := fs.String("f", "all", "output format, md, html or all")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "md" && *format != "html" && *format != "all" {
+		err := newStCtlError(fmt.Sprintf("unknown stdoc format \"%v\", use md, html or all", *format))
+		fmt.Println(err)
+		return err
+	}
+
+	c.directory = *directory
+	c.output = *output
+	c.format = *format
+	return nil
+}
+
+func (c *StDocCommand) Description() string {
+	return "\n\t\t基于 stproto 文件生成 Markdown/HTML 接口文档." +
+		"\n\t\tGenerate Markdown and HTML schema documentation based on stproto files."
+}
+
+func (c *StDocCommand) Exec() int {
+	fileList, err := getStProtoFilesPath(c.directory)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	var dfList []*stDumpFile
+	for _, filePath := range fileList {
+		fmt.Printf("parsing %v...\n", path.Base(filePath))
+		psr, err := loadStProtoFile(filePath)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		dfList = append(dfList, psr.toDumpFile())
+	}
+
+	if c.format == "md" || c.format == "all" {
+		if err := writeMarkdownDoc(path.Join(c.output, "md"), dfList); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	if c.format == "html" || c.format == "all" {
+		if err := writeHTMLDoc(path.Join(c.output, "html"), dfList); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	fmt.Println("stdoc finish >>>>>>>>>>>>>>>>>>>>>")
+	return 0
+}
+
+// docTemplate is satisfied by both text/template and html/template.
+type docTemplate interface {
+	ExecuteTemplate(wr io.Writer, name string, data interface{}) error
+}
+
+func writeMarkdownDoc(dir string, dfList []*stDumpFile) error {
+	tmpl, err := template.New("stdoc").Funcs(template.FuncMap{
+		"base":         path.Base,
+		"join":         strings.Join,
+		"joinComments": joinComments,
+		"markdownType": markdownType,
+		"markdownCell": markdownCell,
+	}).ParseFS(docTemplateFS, "template/doc/markdown.tmpl")
+	if err != nil {
+		return err
+	}
+	return writeDocPages(tmpl, "markdown", dir, "README.md", ".md", dfList)
+}
+
+func writeHTMLDoc(dir string, dfList []*stDumpFile) error {
+	tmpl, err := htmltemplate.New("stdoc").Funcs(htmltemplate.FuncMap{
+		"base":         path.Base,
+		"join":         strings.Join,
+		"joinComments": joinComments,
+		"htmlType":     htmlType,
+	}).ParseFS(docTemplateFS, "template/doc/html.tmpl")
+	if err != nil {
+		return err
+	}
+	return writeDocPages(tmpl, "html", dir, "index.html", ".html", dfList)
+}
+
+// writeDocPages renders the "<name>Index" template into indexName and the
+// "<name>" template once per servant into dir.
+func writeDocPages(tmpl docTemplate, name string, dir string, indexName string, ext string, dfList []*stDumpFile) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name+"Index", dfList); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, indexName), buf.Bytes(), 0666); err != nil {
+		return err
+	}
+
+	for _, df := range dfList {
+		buf.Reset()
+		if err := tmpl.ExecuteTemplate(&buf, name, df); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dir, df.ServantName+ext), buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitDocType splits a type into plain parts and referenced named types,
+// e.g. "map[", "Key", "][]" and "Person" for "map[Key][]Person". Every part
+// at an odd index is a struct or type alias name.
+func splitDocType(typ string) []string {
+	parts := []string{""}
+	last := 0
+	for _, loc := range regTypeIdent.FindAllStringIndex(typ, -1) {
+		word := typ[loc[0]:loc[1]]
+		if _, ok := stBaseTypeMap[word]; ok || word == "map" || word == "set" || word == "oneof" {
+			continue
+		}
+		parts[len(parts)-1] += typ[last:loc[0]]
+		parts = append(parts, word, "")
+		last = loc[1]
+	}
+	parts[len(parts)-1] += typ[last:]
+	return parts
+}
+
+// docTypeNote tells that a type holding timestamps only keeps milliseconds,
+// finer times are truncated on the wire.
+func docTypeNote(typ string) string {
+	for _, word := range regTypeIdent.FindAllString(typ, -1) {
+		if word == "timestamp" {
+			return " (milliseconds)"
+		}
+	}
+	return ""
+}
+
+func markdownType(typ string, optional bool) string {
+	var ret string
+	for i, part := range splitDocType(typ) {
+		if i%2 == 1 {
+			ret += fmt.Sprintf("[%v](#struct-%v)", part, part)
+		} else if part != "" {
+			ret += "`" + part + "`"
+		}
+	}
+	ret += docTypeNote(typ)
+	if optional {
+		ret += " (optional)"
+	}
+	return ret
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+func htmlType(typ string, optional bool) htmltemplate.HTML {
+	var ret string
+	for i, part := range splitDocType(typ) {
+		part = htmltemplate.HTMLEscapeString(part)
+		if i%2 == 1 {
+			ret += fmt.Sprintf("<a href=\"#struct-%v\">%v</a>", part, part)
+		} else if part != "" {
+			ret += "<code>" + part + "</code>"
+		}
+	}
+	ret += docTypeNote(typ)
+	if optional {
+		ret += " (optional)"
+	}
+	return htmltemplate.HTML(ret)
+}
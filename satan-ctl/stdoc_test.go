@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdownDoc(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, testStProto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := writeMarkdownDoc(dir, []*stDumpFile{psr.toDumpFile()}); err != nil {
+		t.Fatal(err)
+	}
+
+	buff, err := ioutil.ReadFile(path.Join(dir, "greeter.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<a id=\"struct-Person\"></a>\n### Person",
+		"| 3 | `friends` | `[]`[Person](#struct-Person) |  |  |",
+		"**Request** `SayHiReq`",
+	} {
+		if !strings.Contains(string(buff), want) {
+			t.Errorf("markdown does not contain %q:\n%v", want, string(buff))
+		}
+	}
+}
+
+func TestDocTypeTimestamp(t *testing.T) {
+	if got, want := markdownType("map[string]timestamp", true), "`map[string]timestamp` (milliseconds) (optional)"; got != want {
+		t.Errorf("markdownType = %q, want %q", got, want)
+	}
+	if got, want := string(htmlType("timestamp", false)), "<code>timestamp</code> (milliseconds)"; got != want {
+		t.Errorf("htmlType = %q, want %q", got, want)
+	}
+	if got := markdownType("[]timestamps", false); strings.Contains(got, "milliseconds") {
+		t.Errorf("markdownType noted a struct named timestamps: %q", got)
+	}
+}
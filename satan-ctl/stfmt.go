@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const stFmtIndent = "    "
+
+var StFmt = &StFmtCommand{}
+
+type StFmtCommand struct {
+	list  bool
+	write bool
+	diff  bool
+	paths []string
+}
+
+func (c *StFmtCommand) ParseArgs(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := fs.Bool("l", false, "list files whose formatting differs from stfmt's")
+	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c.list = *list
+	c.write = *write
+	c.diff = *diff
+	c.paths = fs.Args()
+	if len(c.paths) == 0 {
+		c.paths = []string{"./"}
+	}
+	return nil
+}
+
+func (c *StFmtCommand) Description() string {
+	return "\n\t\t按统一格式重写 stproto 文件 (fmt [-l] [-w] [-d] [path ...])." +
+		"\n\t\tRewrite stproto files in the canonical layout (fmt [-l] [-w] [-d] [path ...])."
+}
+
+func (c *StFmtCommand) Exec() int {
+	for _, p := range c.paths {
+		fileList := []string{p}
+		if info, err := os.Stat(p); err != nil {
+			fmt.Println(err)
+			return 1
+		} else if info.IsDir() {
+			if fileList, err = getStProtoFilesPath(p); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+		}
+
+		for _, filePath := range fileList {
+			if err := c.formatFile(filePath); err != nil {
+				fmt.Printf("%v: %v\n", filePath, err)
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+func (c *StFmtCommand) formatFile(filePath string) error {
+	src, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	stmts, err := parseStSyntax(string(src))
+	if err != nil {
+		return err
+	}
+	res := []byte(formatStProto(stmts))
+
+	if !bytes.Equal(src, res) {
+		if c.list {
+			fmt.Println(filePath)
+		}
+		if c.write {
+			if err := ioutil.WriteFile(filePath, res, 0666); err != nil {
+				return err
+			}
+		}
+		if c.diff {
+			fmt.Print(unifiedDiff(filePath+".orig", filePath, string(src), string(res)))
+		}
+	}
+	if !c.list && !c.write && !c.diff {
+		fmt.Print(string(res))
+	}
+	return nil
+}
+
+// formatStProto prints statements in the canonical layout: four space
+// indentation, one blank line around { } blocks at every depth, so that the
+// funcs of a servant are separated like top-level ones, at most one blank line
+// elsewhere and aligned columns for runs of consecutive single-line statements.
+func formatStProto(stmts []*stSyntaxStmt) string {
+	var sb strings.Builder
+	writeStmts(&sb, stmts, 0)
+	return sb.String()
+}
+
+func writeStmts(sb *strings.Builder, stmts []*stSyntaxStmt, depth int) {
+	indent := strings.Repeat(stFmtIndent, depth)
+	for i := 0; i < len(stmts); {
+		st := stmts[i]
+		if i > 0 {
+			prev := stmts[i-1]
+			if st.blankBefore || len(prev.words) == 0 || st.block == '{' || prev.block == '{' {
+				sb.WriteString("\n")
+			}
+		}
+
+		if st.block != 0 || len(st.words) == 0 {
+			writeDoc(sb, st.doc, indent)
+			if st.block != 0 {
+				writeBlock(sb, st, depth)
+			}
+			i++
+			continue
+		}
+
+		// a run of single-line statements shares its column widths
+		j := i + 1
+		for j < len(stmts) && stmts[j].block == 0 && len(stmts[j].words) > 0 && !stmts[j].blankBefore {
+			j++
+		}
+		writeAligned(sb, stmts[i:j], indent)
+		i = j
+	}
+}
+
+func writeDoc(sb *strings.Builder, doc []string, indent string) {
+	for _, line := range doc {
+		sb.WriteString(indent + formatComment(line) + "\n")
+	}
+}
+
+func writeBlock(sb *strings.Builder, st *stSyntaxStmt, depth int) {
+	indent := strings.Repeat(stFmtIndent, depth)
+	open, closing := " {", "}"
+	if st.block == '(' {
+		open, closing = "(", ")"
+	}
+
+	sb.WriteString(indent + strings.Join(st.words, " ") + open)
+	if st.headComment != "" {
+		sb.WriteString(" " + formatComment(st.headComment))
+	}
+	sb.WriteString("\n")
+	writeStmts(sb, st.children, depth+1)
+	sb.WriteString(indent + closing)
+	if st.comment != "" {
+		sb.WriteString(" " + formatComment(st.comment))
+	}
+	sb.WriteString("\n")
+}
+
+// writeAligned writes statements in three columns: the first word, the second
+// word and the remaining words, followed by the trailing comment.
+func writeAligned(sb *strings.Builder, stmts []*stSyntaxStmt, indent string) {
+	var widths [3]int
+	rows := make([][]string, len(stmts))
+	for i, st := range stmts {
+		row := st.words
+		if isReservedStmt(st) {
+			// the tags and names of a reserved statement stay together
+			row = []string{row[0], strings.Join(row[1:], " ")}
+		} else if len(row) > 3 {
+			row = append(append([]string{}, row[:2]...), strings.Join(row[2:], " "))
+		}
+		for k, col := range row {
+			if w := utf8.RuneCountInString(col); w > widths[k] {
+				widths[k] = w
+			}
+		}
+		rows[i] = row
+	}
+
+	lines := make([]string, len(stmts))
+	lineWidth := 0
+	for i, row := range rows {
+		for k, col := range row {
+			if k > 0 {
+				lines[i] += " "
+			}
+			lines[i] += col
+			if k < len(row)-1 {
+				lines[i] += strings.Repeat(" ", widths[k]-utf8.RuneCountInString(col))
+			}
+		}
+		if w := utf8.RuneCountInString(lines[i]); w > lineWidth {
+			lineWidth = w
+		}
+	}
+
+	for i, st := range stmts {
+		writeDoc(sb, st.doc, indent)
+		line := lines[i]
+		if st.comment != "" {
+			line += strings.Repeat(" ", lineWidth-utf8.RuneCountInString(line)) + " " + formatComment(st.comment)
+		}
+		sb.WriteString(indent + line + "\n")
+	}
+}
+
+// formatComment separates the comment text from "//" by a space unless the
+// text is already indented.
+func formatComment(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return "//"
+	}
+	if strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t") {
+		return "//" + text
+	}
+	return "// " + text
+}
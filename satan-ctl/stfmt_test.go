@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFormatStProto(t *testing.T) {
+	src := `// header
+
+
+// Person is a user.
+struct   Person{   //trailing
+  name string optional   //display name
+  longName map[string][]int  deprecated("a; b")
+
+
+  // detached
+  x bool
+}
+struct Empty { flag bool; other int }
+func SayHi {
+req(who Person) rsp(
+   msg string
+)
+}
+`
+	want := `// header
+
+// Person is a user.
+struct Person { // trailing
+    name     string           optional           // display name
+    longName map[string][]int deprecated("a; b")
+
+    // detached
+    x bool
+}
+
+struct Empty {
+    flag  bool
+    other int
+}
+
+func SayHi {
+    req(
+        who Person
+    )
+    rsp(
+        msg string
+    )
+}
+`
+	stmts, err := parseStSyntax(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := formatStProto(stmts)
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	stmts, err = parseStSyntax(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := formatStProto(stmts); again != got {
+		t.Errorf("formatting is not idempotent:\n%v", again)
+	}
+}
+
+func TestFormatStProtoOneof(t *testing.T) {
+	src := `struct Result { id long
+ oneof  outcome { ok Foo; err   string // failed
+ } }
+`
+	want := `struct Result {
+    id long
+
+    oneof outcome {
+        ok  Foo
+        err string // failed
+    }
+}
+`
+	stmts, err := parseStSyntax(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatStProto(stmts); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestFormatStProtoServants(t *testing.T) {
+	src := `servant Account {
+func Login { req(name string) rsp(ok bool) }
+func Logout oneway { req(name string) }
+}
+servant Admin { func Ban { req(id long) } }
+`
+	want := `servant Account {
+    func Login {
+        req(
+            name string
+        )
+        rsp(
+            ok bool
+        )
+    }
+
+    func Logout oneway {
+        req(
+            name string
+        )
+    }
+}
+
+servant Admin {
+    func Ban {
+        req(
+            id long
+        )
+    }
+}
+`
+	stmts, err := parseStSyntax(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := formatStProto(stmts)
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	stmts, err = parseStSyntax(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := formatStProto(stmts); again != got {
+		t.Errorf("formatting is not idempotent:\n%v", again)
+	}
+}
+
+func TestFormatStProtoReserved(t *testing.T) {
+	src := `struct Person { reserved 1,22; reserved  "old"; flag bool; other int }
+`
+	want := `struct Person {
+    reserved 1, 22
+    reserved "old"
+    flag     bool
+    other    int
+}
+`
+	stmts, err := parseStSyntax(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatStProto(stmts); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestParseStSyntaxError(t *testing.T) {
+	for src, want := range map[string]string{
+		"struct A {\n  a int\n":    "line 3: missing closing \"}\"",
+		"struct A {\n}\n}\n":       "line 3: unexpected \"}\"",
+		"struct A {\n  a \"x\n}\n": "line 2: newline in string",
+		"func F {\n  req(a int\n}": "line 3: unexpected \"}\"",
+	} {
+		if _, err := parseStSyntax(src); err == nil || err.Error() != want {
+			t.Errorf("parseStSyntax(%q) error = %v, want %v", src, err, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got := unifiedDiff("a", "b", "x\ny\nz\n", "x\nY\nz\n")
+	want := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n x\n-y\n+Y\n z\n"
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+	if unifiedDiff("a", "b", "x\n", "x\n") != "" {
+		t.Error("expected no diff for equal input")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStreamFuncs(t *testing.T) {
+	psr, err := loadStProtoFile(writeTestStProto(t, `
+func Chat {
+    stream req(
+        text string
+    )
+    stream rsp(
+        text string
+    )
+}
+
+func Export idempotent {
+    req(
+        filter string
+    )
+    stream rsp(
+        name string
+    )
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf := psr.funcList[0]; !pf.reqStream || !pf.rspStream {
+		t.Errorf("Chat does not stream both ways: %+v", pf)
+	}
+	if pf := psr.funcList[1]; pf.reqStream || !pf.rspStream {
+		t.Errorf("Export does not stream its rsp only: %+v", pf)
+	}
+
+	const body = "{\n    stream req(\n        id long\n    )\n    rsp(\n        ok bool\n    )\n}\n"
+	for text, want := range map[string]string{
+		"func F timeout(1s) " + body:                                    "line 1: stream func F cannot be oneway or have a timeout or retry",
+		"func F idempotent retry(2) " + body:                            "line 1: stream func F cannot be oneway or have a timeout or retry",
+		"func F oneway {\n    stream req(\n        id long\n    )\n}\n": "line 1: stream func F cannot be oneway or have a timeout or retry",
+	} {
+		_, err := loadStProtoFile(writeTestStProto(t, text))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %v", text, err, want)
+		}
+	}
+}
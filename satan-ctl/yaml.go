@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var regYAMLPlain = regexp.MustCompile(`^[a-zA-Z_/.][0-9a-zA-Z_/.-]*$`)
+
+// writeYAML writes v as block-style YAML. It only knows the shapes used by
+// the dump model: structs with json tags, slices, pointers, strings, ints and
+// bools, which keeps satanCtl free of third-party dependencies.
+func writeYAML(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	yamlValue(bw, reflect.ValueOf(v), 0, false)
+	return bw.Flush()
+}
+
+func yamlValue(w *bufio.Writer, v reflect.Value, indent int, inline bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			fmt.Fprintln(w, "null")
+			return
+		}
+		v = v.Elem()
+	}
+
+	pad := strings.Repeat("  ", indent)
+	switch v.Kind() {
+	case reflect.Struct:
+		first := true
+		for i := 0; i < v.NumField(); i++ {
+			name, omitEmpty := yamlFieldName(v.Type().Field(i))
+			fv := v.Field(i)
+			if name == "" || (omitEmpty && yamlIsEmpty(fv)) {
+				continue
+			}
+			if !(first && inline) {
+				w.WriteString(pad)
+			}
+			first = false
+			w.WriteString(name + ":")
+			if yamlIsBlock(fv) {
+				w.WriteString("\n")
+				yamlValue(w, fv, indent+1, false)
+			} else {
+				w.WriteString(" ")
+				yamlValue(w, fv, indent+1, false)
+			}
+		}
+		if first {
+			fmt.Fprintln(w, "{}")
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			w.WriteString(pad + "- ")
+			yamlValue(w, v.Index(i), indent+1, true)
+		}
+	case reflect.String:
+		fmt.Fprintln(w, yamlString(v.String()))
+	default:
+		fmt.Fprintln(w, v.Interface())
+	}
+}
+
+func yamlFieldName(f reflect.StructField) (name string, omitEmpty bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := strings.Split(f.Tag.Get("json"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+	name = tag[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range tag[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+
+// yamlIsEmpty follows the omitempty rules of encoding/json.
+func yamlIsEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// yamlIsBlock reports whether v starts on the line after its key.
+func yamlIsBlock(v reflect.Value) bool {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Slice, reflect.Array:
+		return v.Len() > 0
+	}
+	return false
+}
+
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(s)
+	}
+	if regYAMLPlain.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}